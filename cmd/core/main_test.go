@@ -14,3 +14,10 @@ func TestBasic(t *testing.T) {
 func TestLinked(t *testing.T) {
 	ritmic.RunTestShort(dummy.EvaluateRecursive, [][]float64{{}}, dummy.ParamsRecursive)
 }
+
+func TestLookahead(t *testing.T) {
+	report := ritmic.RunLookaheadTest(dummy.EvaluateAnyCandles, [][]float64{{50}}, dummy.ParamsAnyCandles, 3)
+	if !report.Clean() {
+		t.Fail()
+	}
+}
@@ -10,6 +10,13 @@ var ParamsLastCandle = make([]string, 0)
 var ParamsAnyCandles = []string{"historySize"}
 var ParamsRecursive = make([]string, 0)
 
+// register the types kept in memory so scenarios can be checkpointed
+func init() {
+	env.RegisterMemory(&LocalStoreLastCandle{})
+	env.RegisterMemory(&LocalStoreAnyCandles{})
+	env.RegisterMemory(&candles.Candle{})
+}
+
 type CrossState = int
 
 const (
@@ -115,4 +122,6 @@ func EvaluateRecursive(chart env.MarketSupplier, res *algo.ResultHandler, mem *e
 	highsAndLows.PastEvents()
 	highsAndLows.LastEvents()
 	highsAndLows.Events()
+	highsAndLows.LastWithLabel("high")
+	highsAndLows.EventsInLast(10)
 }
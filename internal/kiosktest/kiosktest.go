@@ -0,0 +1,47 @@
+// Package kiosktest serves a synthetic market for tests which evaluate strategies
+package kiosktest
+
+import (
+	"encoding/json"
+	"github.com/godoji/algocore/internal/kiosktest/market"
+	"github.com/godoji/algocore/pkg/kiosk"
+	"github.com/northberg/candlestick"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Symbols are listed by the synthetic market, see market.Symbols
+var Symbols = market.Symbols
+
+// OnBoard is the on-board date of every symbol, candles exist for every step after it
+const OnBoard = market.OnBoard
+
+// BreakerCooldown is how long requests are rejected once the market failed repeatedly
+const BreakerCooldown = 100 * time.Millisecond
+
+var once sync.Once
+
+// Setup configures the data layer to fetch from the synthetic market, it can be called by
+// every test as the data layer is only configured once. Only the market service is configured,
+// indicator and algorithm requests fail as they would without INCA_URL and ALGO_URL. Failed
+// requests are retried quickly and the circuit breaker closes after BreakerCooldown
+func Setup() {
+	once.Do(func() {
+		srv := httptest.NewServer(market.Handler())
+		cfg := kiosk.DefaultConfig()
+		cfg.KioURL = srv.URL
+		cfg.IncaURL = ""
+		cfg.AlgoURL = ""
+		cfg.RetryBackoff = time.Millisecond
+		cfg.MaxRetryBackoff = 10 * time.Millisecond
+		cfg.BreakerCooldown = BreakerCooldown
+		cfg.CandleDecoder = func(b []byte) (*candlestick.CandleSet, error) {
+			candles := new(candlestick.CandleSet)
+			return candles, json.Unmarshal(b, candles)
+		}
+		if err := kiosk.Setup(cfg); err != nil {
+			panic(err)
+		}
+	})
+}
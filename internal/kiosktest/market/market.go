@@ -0,0 +1,133 @@
+// Package market serves a synthetic market over http, it does not depend on the data layer
+// so the data layer can test against it as well
+package market
+
+import (
+	"encoding/json"
+	"github.com/northberg/candlestick"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Symbols are listed by the synthetic market with candles from OnBoard
+var Symbols = []string{"TEST:X:A", "TEST:X:B", "TEST:X:C", "TEST:X:D"}
+
+// OnBoard is the on-board date of Symbols, candles exist for every step after it
+const OnBoard int64 = 1600000000
+
+// Listed are the other symbols of the market by their on-board date, they live on other
+// exchanges and brokers than Symbols
+var Listed = map[string]int64{
+	"TEST:Y:E": OnBoard + 86400*365,
+	"DEMO:X:F": OnBoard - 86400*365,
+}
+
+var (
+	requests uint64
+	failing  int32
+)
+
+// Handler serves the exchange info and candles of the market
+func Handler() http.Handler {
+	return http.HandlerFunc(serve)
+}
+
+// Requests returns the amount of candle requests served so far
+func Requests() uint64 {
+	return atomic.LoadUint64(&requests)
+}
+
+// SetFailing makes every request fail with a server error until it is reset
+func SetFailing(fail bool) {
+	v := int32(0)
+	if fail {
+		v = 1
+	}
+	atomic.StoreInt32(&failing, v)
+}
+
+// Price is the price of a symbol during the candle starting at the given time
+func Price(symbol string, ts int64) float64 {
+	offset := float64(len(symbol)+int(symbol[len(symbol)-1])) * 0.1
+	return 100 + 10*math.Sin(float64(ts)/86400/7+offset) + 5*math.Sin(float64(ts)/3600/5*offset)
+}
+
+func onBoard(symbol string) int64 {
+	if date, ok := Listed[symbol]; ok {
+		return date
+	}
+	return OnBoard
+}
+
+func serve(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&failing) != 0 {
+		http.Error(w, "market unavailable", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/market/info" {
+		serveInfo(w)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/market/t/") {
+		http.NotFound(w, r)
+		return
+	}
+	atomic.AddUint64(&requests, 1)
+	symbol := strings.TrimPrefix(r.URL.Path, "/market/t/")
+	block, _ := strconv.ParseInt(r.URL.Query().Get("segment"), 10, 64)
+	interval, _ := strconv.ParseInt(r.URL.Query().Get("interval"), 10, 64)
+	if interval <= 0 {
+		http.Error(w, "invalid interval", http.StatusBadRequest)
+		return
+	}
+
+	// candles of an interval start at every multiple of it, closed candles are missing
+	now := time.Now().UTC().Unix()
+	first := onBoard(symbol)
+	set := &candlestick.CandleSet{Candles: make([]candlestick.Candle, candlestick.CandleSetSize)}
+	start := block * interval * candlestick.CandleSetSize
+	for i := range set.Candles {
+		ts := start + int64(i)*interval
+		if ts < first || ts > now {
+			set.Candles[i] = candlestick.Candle{Time: ts, Missing: true}
+			continue
+		}
+		price := Price(symbol, ts)
+		set.Candles[i] = candlestick.Candle{Open: price, High: price + 1, Low: price - 1, Close: price, Volume: 1, Time: ts}
+	}
+	_ = json.NewEncoder(w).Encode(set)
+}
+
+// serveInfo lists every symbol under the exchange info of its broker
+func serveInfo(w http.ResponseWriter) {
+	brokers := make(map[string]map[string]interface{})
+	add := func(symbol string, date int64) {
+		broker := strings.Split(symbol, ":")[0]
+		if brokers[broker] == nil {
+			brokers[broker] = make(map[string]interface{})
+		}
+		brokers[broker][symbol] = map[string]interface{}{"onBoardDate": date}
+	}
+	for _, symbol := range Symbols {
+		add(symbol, OnBoard)
+	}
+	for symbol, date := range Listed {
+		add(symbol, date)
+	}
+	names := make([]string, 0, len(brokers))
+	for broker := range brokers {
+		names = append(names, broker)
+	}
+	sort.Strings(names)
+	exchanges := make([]interface{}, 0, len(names))
+	for _, broker := range names {
+		exchanges = append(exchanges, map[string]interface{}{"brokerId": broker, "symbols": brokers[broker]})
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"exchanges": exchanges})
+}
@@ -0,0 +1,130 @@
+package simulation
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"github.com/northberg/candlestick"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ScenarioState is a scenario simulated up to LastTime together with the memory of the strategy
+// at that time, simulating later candles with the memory continues the scenario. Strategies
+// using derived charts keep bars outside of their memory, their states are Charted and are
+// never continued
+type ScenarioState struct {
+	Results  *algo.ScenarioSet
+	Memory   *env.Memory
+	LastTime int64
+	Charted  bool
+}
+
+// ResultCache keeps scenario states between evaluations. A taken state is owned by the caller
+// until it is put back, concurrent evaluations of the same scenario simulate it from scratch
+type ResultCache interface {
+	Take(key string) (*ScenarioState, bool)
+	Put(key string, state *ScenarioState)
+}
+
+// SetResultCache continues scenarios from the states of previous evaluations, only candles after
+// a cached state are simulated. The version identifies the build of the strategy, states of
+// other versions are never used. Cached evaluations stop at the last closed candle
+func (s *Evaluator) SetResultCache(cache ResultCache, version string) *Evaluator {
+	s.cache = cache
+	s.version = version
+	return s
+}
+
+// cacheKey identifies a scenario by everything its results depend on
+func (s *Evaluator) cacheKey(symbol candlestick.AssetIdentifier, keys []string, params []float64) string {
+	values := make([]string, len(params))
+	for i, p := range params {
+		values[i] = strconv.FormatFloat(p, 'g', -1, 64)
+	}
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%d\x00%d\x00%d\x00%s\x00%s",
+		s.name(), s.version, symbol.ToString(), s.resolution, s.from, s.until,
+		strings.Join(keys, ","), strings.Join(values, ","))))
+	return hex.EncodeToString(h[:])
+}
+
+// resume copies the results of a cached state so events appended by this run never alter
+// results sent before
+func (state *ScenarioState) resume() *algo.ScenarioSet {
+	return &algo.ScenarioSet{
+		Events:     append(make([]*algo.Event, 0, len(state.Results.Events)), state.Results.Events...),
+		Parameters: state.Results.Parameters,
+	}
+}
+
+// ScenarioCache is a ResultCache holding a bounded amount of scenarios, the least recently used
+// scenarios are evicted first. Only the count is bounded, the memory used by a scenario grows
+// with the events it emitted and the memory of the strategy
+type ScenarioCache struct {
+	size    int
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	metrics ScenarioCacheMetrics
+}
+
+type ScenarioCacheMetrics struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+}
+
+type scenarioEntry struct {
+	key   string
+	state *ScenarioState
+}
+
+func NewScenarioCache(size int) *ScenarioCache {
+	return &ScenarioCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (c *ScenarioCache) Take(key string) (*ScenarioState, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		c.metrics.Misses++
+		return nil, false
+	}
+	c.metrics.Hits++
+	c.order.Remove(e)
+	delete(c.entries, key)
+	return e.Value.(*scenarioEntry).state, true
+}
+
+func (c *ScenarioCache) Put(key string, state *ScenarioState) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.order.Remove(e)
+	}
+	c.entries[key] = c.order.PushFront(&scenarioEntry{key: key, state: state})
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*scenarioEntry).key)
+		c.metrics.Evictions++
+	}
+}
+
+func (c *ScenarioCache) Metrics() *ScenarioCacheMetrics {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	metrics := c.metrics
+	metrics.Entries = c.order.Len()
+	return &metrics
+}
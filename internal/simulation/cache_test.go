@@ -0,0 +1,235 @@
+package simulation
+
+import (
+	"fmt"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"github.com/northberg/candlestick"
+	"testing"
+)
+
+func TestScenarioCache(t *testing.T) {
+
+	cache := NewScenarioCache(2)
+	for i, key := range []string{"a", "b", "c"} {
+		cache.Put(key, &ScenarioState{Results: &algo.ScenarioSet{}, Memory: env.NewMemory(), LastTime: int64(i)})
+	}
+
+	if _, ok := cache.Take("a"); ok {
+		fmt.Println("expected least recently used scenario to be evicted")
+		t.Fail()
+	}
+	state, ok := cache.Take("c")
+	if !ok || state.LastTime != 2 {
+		fmt.Println("expected cached scenario")
+		t.FailNow()
+	}
+	if _, ok = cache.Take("c"); ok {
+		fmt.Println("expected taken scenario to be owned by the caller")
+		t.Fail()
+	}
+
+	// continuing a scenario leaves the results of the cached run untouched
+	state.Results.Events = append(state.Results.Events, &algo.Event{Label: "first"})
+	resumed := state.resume()
+	resumed.Events = append(resumed.Events, &algo.Event{Label: "second"})
+	if len(state.Results.Events) != 1 || len(resumed.Events) != 2 {
+		fmt.Println("resumed results share events with the cached run")
+		t.Fail()
+	}
+
+	metrics := cache.Metrics()
+	if metrics.Hits != 1 || metrics.Misses != 2 || metrics.Evictions != 1 || metrics.Entries != 1 {
+		fmt.Printf("unexpected metrics: %+v\n", *metrics)
+		t.Fail()
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+
+	symbol := candlestick.NewAssetIdentifier("A", "B", "C")
+	sim := &Evaluator{resolution: 60, chain: []string{"a"}}
+	sim.SetResultCache(NewScenarioCache(1), "v1")
+	key := sim.cacheKey(symbol, []string{"x"}, []float64{1})
+
+	if sim.cacheKey(symbol, []string{"x"}, []float64{1}) != key {
+		fmt.Println("expected keys of identical scenarios to match")
+		t.Fail()
+	}
+	if sim.cacheKey(symbol, []string{"x"}, []float64{2}) == key {
+		fmt.Println("expected parameters to change the key")
+		t.Fail()
+	}
+	sim.SetResultCache(NewScenarioCache(1), "v2")
+	if sim.cacheKey(symbol, []string{"x"}, []float64{1}) == key {
+		fmt.Println("expected the strategy version to change the key")
+		t.Fail()
+	}
+}
+
+func TestResumeStates(t *testing.T) {
+
+	symbol := candlestick.NewAssetIdentifier("A", "B", "C")
+	cache := NewScenarioCache(4)
+	saved := &ScenarioState{Results: &algo.ScenarioSet{}, Memory: env.NewMemory(), LastTime: 600}
+	sim := (&Evaluator{resolution: 60}).SetResultCache(cache, "v1")
+	sim.Resume(map[string][]*ScenarioState{symbol.ToString(): {nil, saved}})
+
+	// checkpoints take the place of the result cache
+	if sim.takeState(symbol, nil, 0, []float64{1}) != nil || sim.takeState(symbol, nil, 1, []float64{2}) != saved {
+		fmt.Println("expected scenarios to continue from the checkpoint")
+		t.Fail()
+	}
+
+	results := &algo.SymbolResultSet{LastTime: 660, Scenarios: []*algo.ScenarioSet{{}, {}}}
+	sim.putStates(symbol, nil, [][]float64{{1}, {2}}, results, []*env.Memory{env.NewMemory(), env.NewMemory()}, []int64{0, 600}, false)
+	states := sim.States()[symbol.ToString()]
+	if len(states) != 2 || states[0].LastTime != 660 || states[1].LastTime != 660 {
+		fmt.Println("expected states of every scenario to be kept")
+		t.Fail()
+	}
+	if cache.Metrics().Entries != 0 {
+		fmt.Println("expected checkpointed states to stay out of the result cache")
+		t.Fail()
+	}
+}
+
+func TestCachedTail(t *testing.T) {
+
+	opts := crossOptions(1)
+	keys := []string{"threshold"}
+	symbol := candlestick.NewAssetIdentifier("TEST", "X", "A")
+
+	// the first half of the candles, simulated before the second half closed
+	head := opts
+	head.Until = opts.From + (opts.Until-opts.From)/2
+	partial, err := NewEvaluator(head)
+	if err != nil {
+		panic(err)
+	}
+	if err = partial.KeepStates().Run(crossScenarios, keys); err != nil {
+		panic(err)
+	}
+	if len(partial.Results().Symbols[opts.Symbols[0]].Scenarios[1].Events) == 0 {
+		fmt.Println("expected the price to cross the threshold in the head")
+		t.FailNow()
+	}
+
+	full, err := NewEvaluator(opts)
+	if err != nil {
+		panic(err)
+	}
+	if err = full.Run(crossScenarios, keys); err != nil {
+		panic(err)
+	}
+	expected := full.Results().Symbols[opts.Symbols[0]]
+
+	// continuing the cached head with the tail gives the results of a run from scratch
+	cache := NewScenarioCache(len(crossScenarios))
+	cached, err := NewEvaluator(opts)
+	if err != nil {
+		panic(err)
+	}
+	cached.SetResultCache(cache, "v1")
+	for i, state := range partial.States()[opts.Symbols[0]] {
+		if state.LastTime >= expected.LastTime {
+			fmt.Println("expected the head to end before the full run")
+			t.FailNow()
+		}
+		cache.Put(cached.cacheKey(symbol, keys, crossScenarios[i]), state)
+	}
+	if err = cached.Run(crossScenarios, keys); err != nil {
+		panic(err)
+	}
+	if !sameResults(expected, cached.Results().Symbols[opts.Symbols[0]], 0, 1, 2, 3) {
+		fmt.Println("expected cached results continued with the tail to equal a run from scratch")
+		t.Fail()
+	}
+	metrics := cache.Metrics()
+	if metrics.Hits != uint64(len(crossScenarios)) || metrics.Entries != len(crossScenarios) {
+		fmt.Printf("expected every scenario to continue from the cache: %+v\n", *metrics)
+		t.Fail()
+	}
+}
+
+// chartStep emits an event whenever the heikin ashi close crosses its moving average
+func chartStep(chart env.MarketSupplier, res *algo.ResultHandler, mem *env.Memory, params env.Parameters) {
+	store, ok := mem.Read().(*crossMemory)
+	if !ok {
+		store = new(crossMemory)
+	}
+	bars := chart.Chart(env.HeikinAshi(3600))
+	ema := bars.Indicator("ema", int(params.Get("period")))
+	if !ema.Exists() {
+		return
+	}
+	above := bars.Candle().Close > ema.Value()
+	if above && !store.Above {
+		res.NewEvent("up")
+	} else if !above && store.Above {
+		res.NewEvent("down")
+	}
+	store.Above = above
+	mem.Store(store)
+}
+
+func TestCachedChartTail(t *testing.T) {
+
+	opts := crossOptions(1)
+	opts.Step = chartStep
+	keys := []string{"period"}
+	scenarios := [][]float64{{20}, {50}}
+	symbol := candlestick.NewAssetIdentifier("TEST", "X", "A")
+
+	// the head ends shortly after the start of a block, a chart built from that block on has
+	// fewer bars than the moving averages need
+	block := opts.Resolution * candlestick.CandleSetSize
+	head := opts
+	head.Until = (opts.From+(opts.Until-opts.From)/2)/block*block + 10*opts.Resolution
+	partial, err := NewEvaluator(head)
+	if err != nil {
+		panic(err)
+	}
+	if err = partial.KeepStates().Run(scenarios, keys); err != nil {
+		panic(err)
+	}
+
+	full, err := NewEvaluator(opts)
+	if err != nil {
+		panic(err)
+	}
+	if err = full.Run(scenarios, keys); err != nil {
+		panic(err)
+	}
+	expected := full.Results().Symbols[opts.Symbols[0]]
+	if len(expected.Scenarios[0].Events) == 0 {
+		fmt.Println("expected the chart to cross its moving average")
+		t.FailNow()
+	}
+
+	// bars of derived charts are not part of the memory, such scenarios are simulated again
+	cache := NewScenarioCache(len(scenarios))
+	cached, err := NewEvaluator(opts)
+	if err != nil {
+		panic(err)
+	}
+	cached.SetResultCache(cache, "v1")
+	for i, state := range partial.States()[opts.Symbols[0]] {
+		if !state.Charted {
+			fmt.Println("expected states of a charting strategy to be marked")
+			t.Fail()
+		}
+		cache.Put(cached.cacheKey(symbol, keys, scenarios[i]), state)
+	}
+	if err = cached.Run(scenarios, keys); err != nil {
+		panic(err)
+	}
+	if !sameResults(expected, cached.Results().Symbols[opts.Symbols[0]], 0, 1) {
+		fmt.Println("expected cached results of a charting strategy to equal a run from scratch")
+		t.Fail()
+	}
+	if entries := cache.Metrics().Entries; entries != 0 {
+		fmt.Printf("expected states of a charting strategy to stay out of the cache but got %d\n", entries)
+		t.Fail()
+	}
+}
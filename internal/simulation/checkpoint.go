@@ -0,0 +1,76 @@
+package simulation
+
+import (
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"github.com/northberg/candlestick"
+)
+
+// KeepStates records the state of every scenario after a run so it can be continued later,
+// the result cache is not used by evaluators keeping their states
+func (s *Evaluator) KeepStates() *Evaluator {
+	s.keepStates = true
+	return s
+}
+
+// Resume continues scenarios from the states of a previous run indexed by symbol and scenario,
+// scenarios without a state or with a Charted state are simulated from the start. Results only
+// hold the events after the states, the states of the run are kept as well
+func (s *Evaluator) Resume(states map[string][]*ScenarioState) *Evaluator {
+	s.resume = states
+	return s.KeepStates()
+}
+
+// States returns the state of every scenario per symbol after a run which kept its states
+func (s *Evaluator) States() map[string][]*ScenarioState {
+	s.statesLock.Lock()
+	defer s.statesLock.Unlock()
+	return s.states
+}
+
+func (s *Evaluator) stateful() bool {
+	return s.keepStates || s.cache != nil
+}
+
+// takeState finds the state a scenario continues from, nil when it starts from scratch
+func (s *Evaluator) takeState(symbol candlestick.AssetIdentifier, keys []string, i int, params []float64) *ScenarioState {
+	if s.keepStates {
+		states := s.resume[symbol.ToString()]
+		if i < len(states) && states[i] != nil && !states[i].Charted {
+			return states[i]
+		}
+		return nil
+	}
+	if state, ok := s.cache.Take(s.cacheKey(symbol, keys, params)); ok && !state.Charted {
+		return state
+	}
+	return nil
+}
+
+// putStates keeps the states of all scenarios of a symbol after simulating it, states of
+// strategies which used derived charts are recomputed instead of cached
+func (s *Evaluator) putStates(symbol candlestick.AssetIdentifier, keys []string, scenarios [][]float64, results *algo.SymbolResultSet, memories []*env.Memory, resumed []int64, charted bool) {
+	states := make([]*ScenarioState, len(scenarios))
+	for i := range scenarios {
+		last := results.LastTime
+		if resumed[i] > last {
+			last = resumed[i]
+		}
+		states[i] = &ScenarioState{Results: results.Scenarios[i], Memory: memories[i], LastTime: last, Charted: charted}
+	}
+	if !s.keepStates {
+		if charted {
+			return
+		}
+		for i := range scenarios {
+			s.cache.Put(s.cacheKey(symbol, keys, scenarios[i]), states[i])
+		}
+		return
+	}
+	s.statesLock.Lock()
+	defer s.statesLock.Unlock()
+	if s.states == nil {
+		s.states = make(map[string][]*ScenarioState)
+	}
+	s.states[symbol.ToString()] = states
+}
@@ -0,0 +1,295 @@
+package simulation
+
+import (
+	"fmt"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/kiosk"
+	"github.com/northberg/candlestick"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type DependencyKind = string
+
+const (
+	AlgorithmDependency DependencyKind = "algorithm"
+	IndicatorDependency DependencyKind = "indicator"
+)
+
+// Dependency is an algorithm or indicator used by a strategy
+type Dependency struct {
+	Kind     DependencyKind `json:"kind"`
+	Name     string         `json:"name"`
+	Interval int64          `json:"interval,omitempty"`
+	Params   []float64      `json:"params"`
+}
+
+func Algorithm(name string, params ...float64) Dependency {
+	return Dependency{Kind: AlgorithmDependency, Name: name, Params: params}
+}
+
+func Indicator(name string, interval int64, params ...int) Dependency {
+	values := make([]float64, len(params))
+	for i, p := range params {
+		values[i] = float64(p)
+	}
+	return Dependency{Kind: IndicatorDependency, Name: name, Interval: interval, Params: values}
+}
+
+// Key identifies a dependency including its parameters
+func (d Dependency) Key() string {
+	params := make([]string, len(d.Params))
+	for i, p := range d.Params {
+		params[i] = strconv.FormatFloat(p, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%s:%s:%d:%s", d.Kind, d.Name, d.Interval, strings.Join(params, ","))
+}
+
+func (d Dependency) intParams() []int {
+	params := make([]int, len(d.Params))
+	for i, p := range d.Params {
+		params[i] = int(p)
+	}
+	return params
+}
+
+// LocalStrategy is a strategy available in process together with its known dependencies
+type LocalStrategy struct {
+	Step         StepFunction
+	Keys         []string
+	Dependencies []Dependency
+}
+
+// Dependencies looks up strategies available in process by name
+type Dependencies = func(name string) (*LocalStrategy, bool)
+
+// DependencyOrder lists the algorithms a strategy depends on, directly or through other local
+// strategies, in topological order so every algorithm comes after the algorithms it uses
+func DependencyOrder(root string, lookup Dependencies) ([]Dependency, error) {
+	order := make([]Dependency, 0)
+	added := make(map[string]bool)
+	done := make(map[string]bool)
+	var visit func(name string, chain []string) error
+	visit = func(name string, chain []string) error {
+		for _, parent := range chain {
+			if parent == name {
+				return cycleError(append(chain, name))
+			}
+		}
+		if done[name] {
+			return nil
+		}
+		chain = append(chain, name)
+		st, ok := lookup(name)
+		if !ok {
+			done[name] = true
+			return nil
+		}
+		for _, dep := range sortedDependencies(st.Dependencies) {
+			if dep.Kind != AlgorithmDependency {
+				continue
+			}
+			if err := visit(dep.Name, chain); err != nil {
+				return err
+			}
+			if !added[dep.Key()] {
+				added[dep.Key()] = true
+				order = append(order, dep)
+			}
+		}
+		done[name] = true
+		return nil
+	}
+	if err := visit(root, make([]string, 0)); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+func sortedDependencies(deps []Dependency) []Dependency {
+	sorted := append(make([]Dependency, 0, len(deps)), deps...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key() < sorted[j].Key()
+	})
+	return sorted
+}
+
+func cycleError(chain []string) error {
+	return fmt.Errorf("cyclic dependency: %s", strings.Join(chain, " -> "))
+}
+
+// dependencyState is shared by an evaluator and the nested evaluators of its dependencies, it
+// memoises dependency results and collects the dependencies discovered while evaluating
+type dependencyState struct {
+	lookup     Dependencies
+	lock       sync.Mutex
+	results    map[string]*dependencyResult
+	discovered map[string]map[string]Dependency
+}
+
+type dependencyResult struct {
+	done   chan struct{}
+	result *algo.ScenarioSet
+	err    error
+}
+
+func newDependencyState(lookup Dependencies) *dependencyState {
+	return &dependencyState{
+		lookup:     lookup,
+		results:    make(map[string]*dependencyResult),
+		discovered: make(map[string]map[string]Dependency),
+	}
+}
+
+// memoise computes a result once, concurrent callers wait for the first one
+func (d *dependencyState) memoise(key string, compute func() (*algo.ScenarioSet, error)) (*algo.ScenarioSet, error) {
+	d.lock.Lock()
+	r, ok := d.results[key]
+	if !ok {
+		r = &dependencyResult{done: make(chan struct{})}
+		d.results[key] = r
+	}
+	d.lock.Unlock()
+	if ok {
+		<-r.done
+		return r.result, r.err
+	}
+
+	// waiters receive this error when compute panics, the panic itself is passed to the caller
+	r.err = fmt.Errorf("evaluation of %s panicked", key)
+	defer close(r.done)
+	r.result, r.err = compute()
+	return r.result, r.err
+}
+
+func (d *dependencyState) discover(name string, deps []Dependency) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	found, ok := d.discovered[name]
+	if !ok {
+		found = make(map[string]Dependency)
+		d.discovered[name] = found
+	}
+	for _, dep := range deps {
+		found[dep.Key()] = dep
+	}
+}
+
+// SetDependencies evaluates algorithms used by the strategy in process when they are available,
+// other algorithms are requested from the algorithm service
+func (s *Evaluator) SetDependencies(lookup Dependencies) *Evaluator {
+	s.dependencies = newDependencyState(lookup)
+	return s
+}
+
+// Discovered lists the dependencies requested during the last run per strategy, including the
+// strategies evaluated as dependencies
+func (s *Evaluator) Discovered() map[string][]Dependency {
+	result := make(map[string][]Dependency)
+	if s.dependencies == nil {
+		return result
+	}
+	s.dependencies.lock.Lock()
+	defer s.dependencies.lock.Unlock()
+	for name, found := range s.dependencies.discovered {
+		deps := make([]Dependency, 0, len(found))
+		for _, dep := range found {
+			deps = append(deps, dep)
+		}
+		result[name] = sortedDependencies(deps)
+	}
+	return result
+}
+
+func (s *Evaluator) name() string {
+	if len(s.chain) == 0 {
+		return ""
+	}
+	return s.chain[len(s.chain)-1]
+}
+
+// resolve evaluates a dependency of a symbol, local strategies are evaluated on demand with a
+// nested evaluator over the full history, other algorithms are requested from the algorithm service
+func (s *Evaluator) resolve(symbol candlestick.AssetIdentifier, name string, params []float64) (*algo.ScenarioSet, error) {
+
+	chain := append(append(make([]string, 0, len(s.chain)+1), s.chain...), name)
+	for _, parent := range s.chain {
+		if parent == name {
+			return nil, cycleError(chain)
+		}
+	}
+
+	key := fmt.Sprintf("%s:%d:%d:%s", symbol.ToString(), s.resolution, s.until, Algorithm(name, params...).Key())
+	return s.dependencies.memoise(key, func() (*algo.ScenarioSet, error) {
+		st, ok := s.dependencies.lookup(name)
+		if !ok {
+			result, err := kiosk.GetAlgorithm(name, s.resolution, symbol.ToString(), params, true)
+			if err == nil && result == nil {
+				err = fmt.Errorf("algorithm \"%s\" does not exist", name)
+			}
+			return result, err
+		}
+		nested := &Evaluator{
+			step:            st.Step,
+			symbols:         []candlestick.AssetIdentifier{symbol},
+			resolution:      s.resolution,
+			until:           s.until,
+			maxThreads:      1,
+			prefetch:        s.prefetch,
+			scenarioThreads: 1,
+			dependencies:    s.dependencies,
+			chain:           chain,
+		}
+		if err := nested.Run([][]float64{params}, st.Keys); err != nil {
+			return nil, err
+		}
+		return nested.results.Symbols[symbol.ToString()].Scenarios[0], nil
+	})
+}
+
+// resolver resolves every algorithm requested by a strategy through the shared dependency state
+func (s *Evaluator) resolver(symbol candlestick.AssetIdentifier) kiosk.AlgorithmResolver {
+	return func(name string, params []float64) (*algo.ScenarioSet, bool, error) {
+		result, err := s.resolve(symbol, name, params)
+		return result, true, err
+	}
+}
+
+// prepare fetches the dependencies of a symbol in topological order before the simulation starts
+// and announces the declared indicators to the prefetcher
+func (s *Evaluator) prepare(symbol candlestick.AssetIdentifier, provider *kiosk.Provider) error {
+	name := s.name()
+	st, ok := s.dependencies.lookup(name)
+	if !ok {
+		return nil
+	}
+	for _, dep := range st.Dependencies {
+		if dep.Kind == IndicatorDependency {
+			provider.ExpectIndicator(dep.Name, dep.Interval, dep.intParams())
+		}
+	}
+	order, err := DependencyOrder(name, s.dependencies.lookup)
+	if err != nil {
+		return err
+	}
+	for _, dep := range order {
+		if _, err = s.resolve(symbol, dep.Name, dep.Params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collect records the dependencies the strategy requested while simulating a symbol
+func (s *Evaluator) collect(provider *kiosk.Provider, algorithms *kiosk.AlgorithmStore) {
+	deps := make([]Dependency, 0)
+	for _, req := range algorithms.Requested() {
+		deps = append(deps, Algorithm(req.Name, req.Params...))
+	}
+	for _, req := range provider.Indicators() {
+		deps = append(deps, Indicator(req.Name, req.Interval, req.Params...))
+	}
+	s.dependencies.discover(s.name(), deps)
+}
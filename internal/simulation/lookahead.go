@@ -0,0 +1,413 @@
+package simulation
+
+import (
+	"fmt"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"github.com/northberg/candlestick"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Violation is a data access that returned information timestamped after the current step
+type Violation struct {
+	Method   string `json:"method"`
+	CallSite string `json:"callSite"`
+	Time     int64  `json:"time"`
+	Accessed int64  `json:"accessed"`
+	Count    int    `json:"count"`
+}
+
+// Mismatch is a scenario whose events differ between the full and a truncated history
+type Mismatch struct {
+	Symbol     string `json:"symbol"`
+	Scenario   int    `json:"scenario"`
+	Cutoff     int64  `json:"cutoff"`
+	Expected   int    `json:"expected"`
+	Actual     int    `json:"actual"`
+	Difference int64  `json:"difference"`
+}
+
+type LookaheadReport struct {
+	Violations []*Violation `json:"violations"`
+	Mismatches []*Mismatch  `json:"mismatches"`
+}
+
+func (r *LookaheadReport) Clean() bool {
+	return len(r.Violations) == 0 && len(r.Mismatches) == 0
+}
+
+type lookaheadRecorder struct {
+	lock       sync.Mutex
+	violations map[string]*Violation
+}
+
+func newLookaheadRecorder() *lookaheadRecorder {
+	return &lookaheadRecorder{
+		violations: make(map[string]*Violation),
+	}
+}
+
+func (r *lookaheadRecorder) record(method string, now int64, accessed int64, skip int) {
+	callSite := "unknown"
+	if _, file, line, ok := runtime.Caller(skip + 1); ok {
+		callSite = fmt.Sprintf("%s:%d", file, line)
+	}
+	key := method + "@" + callSite
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if v, ok := r.violations[key]; ok {
+		v.Count++
+		return
+	}
+	r.violations[key] = &Violation{
+		Method:   method,
+		CallSite: callSite,
+		Time:     now,
+		Accessed: accessed,
+		Count:    1,
+	}
+}
+
+func (r *lookaheadRecorder) list() []*Violation {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	result := make([]*Violation, 0, len(r.violations))
+	for _, v := range r.violations {
+		result = append(result, v)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CallSite < result[j].CallSite
+	})
+	return result
+}
+
+// instrument wraps a step function so every supplier access is checked against the step time,
+// data after a non-zero cutoff is hidden from the strategy
+func instrument(step StepFunction, rec *lookaheadRecorder, cutoff int64, resolution int64) StepFunction {
+	return func(chart env.MarketSupplier, res *algo.ResultHandler, mem *env.Memory, params env.Parameters) {
+		step(&lookaheadSupplier{chart: chart, rec: rec, cutoff: cutoff, resolution: resolution}, res, mem, params)
+	}
+}
+
+type lookaheadSupplier struct {
+	chart      env.MarketSupplier
+	rec        *lookaheadRecorder
+	cutoff     int64
+	resolution int64
+}
+
+func (s *lookaheadSupplier) Algorithm(name string, params ...float64) env.AlgorithmSupplier {
+	return &lookaheadAlgorithm{
+		supplier: s.chart.Algorithm(name, params...),
+		parent:   s,
+	}
+}
+
+func (s *lookaheadSupplier) Interval(interval int64) env.IntervalSupplier {
+	return &lookaheadInterval{
+		supplier: s.chart.Interval(interval),
+		parent:   s,
+		interval: interval,
+	}
+}
+
+func (s *lookaheadSupplier) Chart(spec env.ChartSpec) env.IntervalSupplier {
+	return &lookaheadInterval{
+		supplier: s.chart.Chart(spec),
+		parent:   s,
+	}
+}
+
+func (s *lookaheadSupplier) Price() float64 {
+	return s.chart.Price()
+}
+
+func (s *lookaheadSupplier) Time() int64 {
+	return s.chart.Time()
+}
+
+// candle records reads of future candles and hides candles beyond the cutoff of a truncated run
+func (s *lookaheadSupplier) candle(method string, c *candlestick.Candle) *candlestick.Candle {
+	now := s.chart.Time()
+	if c.Time > now {
+		s.rec.record(method, now, c.Time, 2)
+	}
+	if s.cutoff != 0 && c.Time > s.cutoff {
+		return &candlestick.Candle{Time: c.Time, Missing: true}
+	}
+	return c
+}
+
+type lookaheadInterval struct {
+	supplier env.IntervalSupplier
+	parent   *lookaheadSupplier
+	// interval of the bars, zero for custom charts
+	interval int64
+}
+
+func (s *lookaheadInterval) Candle() *candlestick.Candle {
+	return s.parent.candle("Candle", s.supplier.Candle())
+}
+
+func (s *lookaheadInterval) FromLast(offset int) *candlestick.Candle {
+	return s.parent.candle("FromLast", s.supplier.FromLast(offset))
+}
+
+func (s *lookaheadInterval) ToTimeStamp(index int64) int64 {
+	ts := s.supplier.ToTimeStamp(index)
+	if now := s.parent.chart.Time(); ts > now {
+		s.parent.rec.record("ToTimeStamp", now, ts, 1)
+	}
+	return ts
+}
+
+func (s *lookaheadInterval) ToIndex(timeStamp int64) int64 {
+	if now := s.parent.chart.Time(); timeStamp > now {
+		s.parent.rec.record("ToIndex", now, timeStamp, 1)
+	}
+	return s.supplier.ToIndex(timeStamp)
+}
+
+func (s *lookaheadInterval) Indicator(name string, params ...int) env.IndicatorSupplier {
+	return &lookaheadIndicator{
+		supplier: s.supplier.Indicator(name, params...),
+		parent:   s,
+	}
+}
+
+type lookaheadIndicator struct {
+	supplier env.IndicatorSupplier
+	parent   *lookaheadInterval
+}
+
+// visible records reads of indicator bars closing after the current step, such values are
+// computed from candles the strategy has not seen yet. It returns false when the bar closes
+// after the cutoff of a truncated run
+func (s *lookaheadIndicator) visible(method string) bool {
+	interval := s.parent.interval
+	if interval <= 0 {
+		return true
+	}
+	p := s.parent.parent
+	now := p.chart.Time()
+	closes := now - now%interval + interval
+	if closes > now+p.resolution {
+		p.rec.record(method, now, closes, 2)
+	}
+	return p.cutoff == 0 || closes <= p.cutoff+p.resolution
+}
+
+func (s *lookaheadIndicator) Value() float64 {
+	if !s.visible("Indicator.Value") {
+		return math.NaN()
+	}
+	return s.supplier.Value()
+}
+
+func (s *lookaheadIndicator) Exists() bool {
+	if !s.visible("Indicator.Exists") {
+		return false
+	}
+	return s.supplier.Exists()
+}
+
+func (s *lookaheadIndicator) Series(key string) float64 {
+	if !s.visible("Indicator.Series") {
+		return math.NaN()
+	}
+	return s.supplier.Series(key)
+}
+
+type lookaheadAlgorithm struct {
+	supplier env.AlgorithmSupplier
+	parent   *lookaheadSupplier
+}
+
+// check records the first event created after the current step, skip is the amount of frames
+// between the strategy and the supplier method calling check
+func (s *lookaheadAlgorithm) check(method string, events []*algo.Event, skip int) []*algo.Event {
+	now := s.parent.chart.Time()
+	for _, e := range events {
+		if e.CreatedOn > now {
+			s.parent.rec.record(method, now, e.CreatedOn, skip+1)
+			break
+		}
+	}
+	return events
+}
+
+func (s *lookaheadAlgorithm) Events() []*algo.Event {
+	return s.check("Events", s.supplier.Events(), 1)
+}
+
+func (s *lookaheadAlgorithm) PastEvents() []*algo.Event {
+	return s.check("PastEvents", s.supplier.PastEvents(), 1)
+}
+
+func (s *lookaheadAlgorithm) LastEvents() []*algo.Event {
+	return s.check("LastEvents", s.supplier.LastEvents(), 1)
+}
+
+func (s *lookaheadAlgorithm) HasEvents() bool {
+	s.check("HasEvents", s.supplier.LastEvents(), 1)
+	return s.supplier.HasEvents()
+}
+
+func (s *lookaheadAlgorithm) checkOne(method string, event *algo.Event) *algo.Event {
+	if event != nil {
+		s.check(method, []*algo.Event{event}, 2)
+	}
+	return event
+}
+
+func (s *lookaheadAlgorithm) LastEvent() *algo.Event {
+	return s.checkOne("LastEvent", s.supplier.LastEvent())
+}
+
+func (s *lookaheadAlgorithm) EventsSince(timestamp int64) []*algo.Event {
+	return s.check("EventsSince", s.supplier.EventsSince(timestamp), 1)
+}
+
+func (s *lookaheadAlgorithm) EventsInLast(candles int) []*algo.Event {
+	return s.check("EventsInLast", s.supplier.EventsInLast(candles), 1)
+}
+
+func (s *lookaheadAlgorithm) CountSince(timestamp int64) int {
+	s.check("CountSince", s.supplier.EventsSince(timestamp), 1)
+	return s.supplier.CountSince(timestamp)
+}
+
+func (s *lookaheadAlgorithm) Filter(filter env.EventFilter) []*algo.Event {
+	return s.check("Filter", s.supplier.Filter(filter), 1)
+}
+
+func (s *lookaheadAlgorithm) FilterSince(filter env.EventFilter, timestamp int64) []*algo.Event {
+	return s.check("FilterSince", s.supplier.FilterSince(filter, timestamp), 1)
+}
+
+func (s *lookaheadAlgorithm) LastMatching(filter env.EventFilter) *algo.Event {
+	return s.checkOne("LastMatching", s.supplier.LastMatching(filter))
+}
+
+func (s *lookaheadAlgorithm) LastWithLabel(label string) *algo.Event {
+	return s.checkOne("LastWithLabel", s.supplier.LastWithLabel(label))
+}
+
+// DetectLookahead runs a strategy over its full history and over histories truncated at
+// several points, reporting supplier reads of future data and events that change when
+// the future is not available
+func DetectLookahead(opts EvalOptions, scenarios [][]float64, keys []string, truncations int) (*LookaheadReport, error) {
+
+	// full history run, reads of future data in every run are recorded together
+	rec := newLookaheadRecorder()
+	fullOpts := opts
+	fullOpts.Step = instrument(opts.Step, rec, 0, opts.Resolution)
+	evaluator, err := NewEvaluator(fullOpts)
+	if err != nil {
+		return nil, err
+	}
+	if err = evaluator.Run(scenarios, keys); err != nil {
+		return nil, err
+	}
+	full := evaluator.Results()
+
+	// pick cutoffs spread over the emitted events
+	cutoffs := lookaheadCutoffs(full, truncations)
+
+	report := &LookaheadReport{
+		Mismatches: make([]*Mismatch, 0),
+	}
+	for _, cutoff := range cutoffs {
+		truncOpts := opts
+		truncOpts.Step = instrument(opts.Step, rec, cutoff, opts.Resolution)
+		truncOpts.Until = cutoff
+		evaluator, err = NewEvaluator(truncOpts)
+		if err != nil {
+			return nil, err
+		}
+		if err = evaluator.Run(scenarios, keys); err != nil {
+			return nil, err
+		}
+		truncated := evaluator.Results()
+		report.Mismatches = append(report.Mismatches, compareTruncated(full, truncated, cutoff)...)
+	}
+
+	report.Violations = rec.list()
+	return report, nil
+}
+
+func lookaheadCutoffs(results *algo.ResultSet, truncations int) []int64 {
+	times := make([]int64, 0)
+	for _, symbol := range results.Symbols {
+		for _, scenario := range symbol.Scenarios {
+			for _, e := range scenario.Events {
+				times = append(times, e.CreatedOn)
+			}
+		}
+	}
+	if len(times) == 0 || truncations < 1 {
+		return nil
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	cutoffs := make([]int64, 0, truncations)
+	for i := 1; i <= truncations; i++ {
+		ts := times[(len(times)-1)*i/(truncations+1)]
+		if len(cutoffs) > 0 && cutoffs[len(cutoffs)-1] == ts {
+			continue
+		}
+		cutoffs = append(cutoffs, ts)
+	}
+	return cutoffs
+}
+
+func compareTruncated(full *algo.ResultSet, truncated *algo.ResultSet, cutoff int64) []*Mismatch {
+	mismatches := make([]*Mismatch, 0)
+	for symbol, expected := range full.Symbols {
+		actual, ok := truncated.Symbols[symbol]
+		if !ok {
+			continue
+		}
+		for i, scenario := range expected.Scenarios {
+			want := make([]*algo.Event, 0)
+			for _, e := range scenario.Events {
+				if e.CreatedOn <= cutoff {
+					want = append(want, e)
+				}
+			}
+			got := actual.Scenarios[i].Events
+			if diff, ok := firstDifference(want, got); !ok {
+				mismatches = append(mismatches, &Mismatch{
+					Symbol:     symbol,
+					Scenario:   i,
+					Cutoff:     cutoff,
+					Expected:   len(want),
+					Actual:     len(got),
+					Difference: diff,
+				})
+			}
+		}
+	}
+	return mismatches
+}
+
+// firstDifference returns the creation time of the first event that differs between both lists
+func firstDifference(a []*algo.Event, b []*algo.Event) (int64, bool) {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].CreatedOn != b[i].CreatedOn || a[i].Label != b[i].Label || a[i].Time != b[i].Time || a[i].Price != b[i].Price {
+			if a[i].CreatedOn < b[i].CreatedOn {
+				return a[i].CreatedOn, false
+			}
+			return b[i].CreatedOn, false
+		}
+	}
+	if len(a) > len(b) {
+		return a[len(b)].CreatedOn, false
+	}
+	if len(b) > len(a) {
+		return b[len(a)].CreatedOn, false
+	}
+	return 0, true
+}
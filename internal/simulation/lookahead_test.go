@@ -0,0 +1,183 @@
+package simulation
+
+import (
+	"fmt"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"github.com/northberg/candlestick"
+	"strings"
+	"testing"
+)
+
+type fakeSupplier struct {
+	now int64
+}
+
+func (s *fakeSupplier) Algorithm(string, ...float64) env.AlgorithmSupplier { return &fakeAlgorithm{s} }
+func (s *fakeSupplier) Interval(int64) env.IntervalSupplier                { return &fakeInterval{s} }
+func (s *fakeSupplier) Chart(env.ChartSpec) env.IntervalSupplier           { return &fakeInterval{s} }
+func (s *fakeSupplier) Price() float64                                     { return 1 }
+func (s *fakeSupplier) Time() int64                                        { return s.now }
+
+type fakeInterval struct {
+	parent *fakeSupplier
+}
+
+func (s *fakeInterval) Candle() *candlestick.Candle {
+	return &candlestick.Candle{Time: s.parent.now}
+}
+func (s *fakeInterval) FromLast(offset int) *candlestick.Candle {
+	return &candlestick.Candle{Time: s.parent.now - int64(offset)}
+}
+func (s *fakeInterval) ToTimeStamp(index int64) int64                  { return s.parent.now + index }
+func (s *fakeInterval) ToIndex(ts int64) int64                         { return ts - s.parent.now }
+func (s *fakeInterval) Indicator(string, ...int) env.IndicatorSupplier { return &fakeIndicator{} }
+
+type fakeIndicator struct{}
+
+func (s *fakeIndicator) Value() float64        { return 1 }
+func (s *fakeIndicator) Exists() bool          { return true }
+func (s *fakeIndicator) Series(string) float64 { return 1 }
+
+// fakeAlgorithm always returns an event created one second after the current step
+type fakeAlgorithm struct {
+	parent *fakeSupplier
+}
+
+func (s *fakeAlgorithm) future() []*algo.Event {
+	return []*algo.Event{{CreatedOn: s.parent.now + 1}}
+}
+func (s *fakeAlgorithm) Events() []*algo.Event                            { return s.future() }
+func (s *fakeAlgorithm) PastEvents() []*algo.Event                        { return s.future() }
+func (s *fakeAlgorithm) LastEvents() []*algo.Event                        { return s.future() }
+func (s *fakeAlgorithm) HasEvents() bool                                  { return true }
+func (s *fakeAlgorithm) LastEvent() *algo.Event                           { return s.future()[0] }
+func (s *fakeAlgorithm) EventsSince(int64) []*algo.Event                  { return s.future() }
+func (s *fakeAlgorithm) EventsInLast(int) []*algo.Event                   { return s.future() }
+func (s *fakeAlgorithm) CountSince(int64) int                             { return 1 }
+func (s *fakeAlgorithm) Filter(env.EventFilter) []*algo.Event             { return s.future() }
+func (s *fakeAlgorithm) FilterSince(env.EventFilter, int64) []*algo.Event { return s.future() }
+func (s *fakeAlgorithm) LastMatching(env.EventFilter) *algo.Event         { return s.future()[0] }
+func (s *fakeAlgorithm) LastWithLabel(string) *algo.Event                 { return s.future()[0] }
+
+func TestLookaheadSupplier(t *testing.T) {
+
+	rec := newLookaheadRecorder()
+	step := instrument(func(chart env.MarketSupplier, res *algo.ResultHandler, mem *env.Memory, params env.Parameters) {
+		chart.Interval(1).Candle()
+		chart.Interval(1).FromLast(2)
+		chart.Interval(1).FromLast(-1)
+		chart.Interval(1).ToIndex(chart.Time() + 5)
+	}, rec, 0, 1)
+
+	for i := int64(0); i < 3; i++ {
+		step(&fakeSupplier{now: 100 + i}, nil, nil, nil)
+	}
+
+	violations := rec.list()
+	if len(violations) != 2 {
+		fmt.Printf("expected %d violations but got %d\n", 2, len(violations))
+		t.FailNow()
+	}
+	for _, v := range violations {
+		if v.Count != 3 {
+			fmt.Printf("expected %d occurrences of %s but got %d\n", 3, v.Method, v.Count)
+			t.Fail()
+		}
+	}
+}
+
+func TestLookaheadCutoff(t *testing.T) {
+
+	rec := newLookaheadRecorder()
+	chart := &lookaheadSupplier{chart: &fakeSupplier{now: 100}, rec: rec, cutoff: 100, resolution: 1}
+
+	if c := chart.Interval(1).FromLast(-1); !c.Missing {
+		fmt.Printf("expected candle at %d to be hidden\n", c.Time)
+		t.Fail()
+	}
+	if c := chart.Interval(1).Candle(); c.Missing {
+		fmt.Printf("expected candle at %d to be visible\n", c.Time)
+		t.Fail()
+	}
+}
+
+func TestLookaheadIndicator(t *testing.T) {
+
+	// the hourly bar containing a minute step closes after it
+	rec := newLookaheadRecorder()
+	chart := &lookaheadSupplier{chart: &fakeSupplier{now: 3600 + 60}, rec: rec, resolution: 60}
+	if v := chart.Interval(3600).Indicator("sma", 20).Value(); v != 1 {
+		fmt.Printf("expected indicator value but got %f\n", v)
+		t.Fail()
+	}
+	if chart.Interval(60).Indicator("sma", 20).Exists(); len(rec.list()) != 1 {
+		fmt.Printf("expected %d violations but got %d\n", 1, len(rec.list()))
+		t.Fail()
+	}
+
+	// truncated runs hide bars closing after the cutoff
+	chart.cutoff = 3600 + 60
+	if chart.Interval(3600).Indicator("sma", 20).Exists() {
+		fmt.Println("expected indicator beyond the cutoff to be hidden")
+		t.Fail()
+	}
+	if !chart.Interval(60).Indicator("sma", 20).Exists() {
+		fmt.Println("expected indicator before the cutoff to be visible")
+		t.Fail()
+	}
+}
+
+func TestLookaheadHasEvents(t *testing.T) {
+
+	rec := newLookaheadRecorder()
+	chart := &lookaheadSupplier{chart: &fakeSupplier{now: 100}, rec: rec, resolution: 1}
+	chart.Algorithm("trend").HasEvents()
+	if violations := rec.list(); len(violations) != 1 || violations[0].Method != "HasEvents" {
+		fmt.Println("expected events checked by HasEvents to be recorded")
+		t.Fail()
+	}
+}
+
+func TestLookaheadCallSite(t *testing.T) {
+
+	rec := newLookaheadRecorder()
+	chart := &lookaheadSupplier{chart: &fakeSupplier{now: 100}, rec: rec, resolution: 1}
+	alg := chart.Algorithm("trend")
+	alg.Events()
+	alg.HasEvents()
+	alg.LastEvent()
+	alg.CountSince(0)
+	alg.LastMatching(env.EventFilter{})
+	alg.LastWithLabel("up")
+	chart.Interval(1).FromLast(-1)
+	chart.Interval(60).Indicator("sma", 20).Value()
+
+	// violations point at the strategy reading the data
+	violations := rec.list()
+	if len(violations) != 8 {
+		fmt.Printf("expected %d violations but got %d\n", 8, len(violations))
+		t.Fail()
+	}
+	for _, v := range violations {
+		if !strings.Contains(v.CallSite, "lookahead_test.go") {
+			fmt.Printf("expected call site of %s in the test but got %s\n", v.Method, v.CallSite)
+			t.Fail()
+		}
+	}
+}
+
+func TestFirstDifference(t *testing.T) {
+
+	a := []*algo.Event{{CreatedOn: 1, Label: "up"}, {CreatedOn: 5, Label: "down"}}
+	b := []*algo.Event{{CreatedOn: 1, Label: "up"}}
+
+	if _, ok := firstDifference(a, a); !ok {
+		fmt.Println("expected identical events to match")
+		t.Fail()
+	}
+	if ts, ok := firstDifference(a, b); ok || ts != 5 {
+		fmt.Printf("expected difference at %d but got %d\n", 5, ts)
+		t.Fail()
+	}
+}
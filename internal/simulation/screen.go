@@ -0,0 +1,63 @@
+package simulation
+
+import (
+	"github.com/godoji/algocore/pkg/algo"
+	"sort"
+)
+
+type ScreenMatch struct {
+	Symbol   string        `json:"symbol"`
+	Scenario int           `json:"scenario"`
+	Time     int64         `json:"time"`
+	Events   []*algo.Event `json:"events"`
+}
+
+type ScreenResult struct {
+	Matches []*ScreenMatch `json:"matches"`
+}
+
+// Screen runs the evaluator and keeps only the events created at the last evaluated step of
+// every symbol, use From and Until to evaluate a short history up to a single recent timestamp
+func (s *Evaluator) Screen(scenarios [][]float64, keys []string) (*ScreenResult, error) {
+
+	if err := s.Run(scenarios, keys); err != nil {
+		return nil, err
+	}
+
+	result := &ScreenResult{
+		Matches: make([]*ScreenMatch, 0),
+	}
+	for symbol, symbolResults := range s.results.Symbols {
+		if symbolResults.LastTime == 0 {
+			continue
+		}
+		for i, scenario := range symbolResults.Scenarios {
+			events := make([]*algo.Event, 0)
+			for _, e := range scenario.Events {
+				if e.CreatedOn == symbolResults.LastTime {
+					events = append(events, e)
+				}
+			}
+			if len(events) == 0 {
+				continue
+			}
+			result.Matches = append(result.Matches, &ScreenMatch{
+				Symbol:   symbol,
+				Scenario: i,
+				Time:     symbolResults.LastTime,
+				Events:   events,
+			})
+		}
+	}
+
+	// stable order regardless of scheduling
+	sort.Slice(result.Matches, func(i, j int) bool {
+		a, b := result.Matches[i], result.Matches[j]
+		if a.Symbol != b.Symbol {
+			return a.Symbol < b.Symbol
+		}
+		return a.Scenario < b.Scenario
+	})
+
+	return result, nil
+}
@@ -0,0 +1,64 @@
+package simulation
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScreen(t *testing.T) {
+
+	opts := crossOptions(2)
+	keys := []string{"threshold"}
+	full, err := NewEvaluator(opts)
+	if err != nil {
+		panic(err)
+	}
+	if err = full.Run(crossScenarios, keys); err != nil {
+		panic(err)
+	}
+
+	// screen up to an event of the first symbol, the second symbol has no event at that time
+	events := full.Results().Symbols[opts.Symbols[0]].Scenarios[1].Events
+	if len(events) < 2 {
+		fmt.Println("expected the price to cross the threshold")
+		t.FailNow()
+	}
+	at := events[1].CreatedOn
+	screen := opts
+	screen.From = at - opts.Resolution*200
+	screen.Until = at
+	screener, err := NewEvaluator(screen)
+	if err != nil {
+		panic(err)
+	}
+	result, err := screener.Screen(crossScenarios, keys)
+	if err != nil {
+		panic(err)
+	}
+
+	// only events at the last evaluated step are kept
+	found := false
+	for _, match := range result.Matches {
+		if match.Time != at {
+			fmt.Printf("expected match at %d but got %d\n", at, match.Time)
+			t.Fail()
+		}
+		for _, e := range match.Events {
+			if e.CreatedOn != at {
+				fmt.Printf("expected only events at %d but got one at %d\n", at, e.CreatedOn)
+				t.Fail()
+			}
+		}
+		if match.Symbol == opts.Symbols[0] && match.Scenario == 1 {
+			found = len(match.Events) == 1 && match.Events[0].Label == events[1].Label
+		}
+	}
+	if !found {
+		fmt.Printf("expected the event at %d to match: %+v\n", at, result.Matches)
+		t.Fail()
+	}
+	if len(screener.Results().Symbols[opts.Symbols[0]].Scenarios[1].Events) < 2 {
+		fmt.Println("expected the warmup to emit earlier events which are not matched")
+		t.Fail()
+	}
+}
@@ -1,13 +1,14 @@
 package simulation
 
 import (
+	"fmt"
 	threading "github.com/aelbrecht/go-threader"
 	"github.com/godoji/algocore/pkg/algo"
 	"github.com/godoji/algocore/pkg/env"
 	"github.com/godoji/algocore/pkg/kiosk"
 	"github.com/northberg/candlestick"
+	"math"
 	"runtime"
-	"strings"
 	"sync"
 	"time"
 )
@@ -25,16 +26,82 @@ type Evaluator struct {
 	step       StepFunction
 	symbols    []candlestick.AssetIdentifier
 	resolution int64
+	from       int64
+	until      int64
 	maxThreads int
-	metrics    algo.Status
-	results    *algo.ResultSet
+	prefetch   int
+	// scenarioThreads shards the scenarios of every symbol over workers
+	scenarioThreads int
+	slots           Slots
+	dependencies    *dependencyState
+	// cache continues scenarios of previous evaluations of the same version
+	cache   ResultCache
+	version string
+	// resume continues scenarios from checkpoints, states keeps the states after a run
+	resume     map[string][]*ScenarioState
+	keepStates bool
+	statesLock sync.Mutex
+	states     map[string][]*ScenarioState
+	// chain holds the names of the strategies being evaluated to detect cyclic dependencies
+	chain   []string
+	metrics algo.Status
+	results *algo.ResultSet
 }
 
+// SetMaxThreads sets the amount of symbols evaluated in parallel
 func (s *Evaluator) SetMaxThreads(threads int) *Evaluator {
 	s.maxThreads = threads
 	return s
 }
 
+// SetScenarioThreads sets the amount of workers sharing the scenarios of a single symbol,
+// the total amount of workers is the product with the max threads
+func (s *Evaluator) SetScenarioThreads(threads int) *Evaluator {
+	s.scenarioThreads = threads
+	return s
+}
+
+// SetThreadSplit divides a budget of workers between symbols and scenarios of a symbol,
+// scenario workers are only used when there are fewer symbols than workers
+func (s *Evaluator) SetThreadSplit(threads int, scenarios int) *Evaluator {
+	symbolThreads := len(s.symbols)
+	if symbolThreads > threads {
+		symbolThreads = threads
+	}
+	if symbolThreads < 1 {
+		symbolThreads = 1
+	}
+	scenarioThreads := threads / symbolThreads
+	if scenarioThreads > scenarios {
+		scenarioThreads = scenarios
+	}
+	if scenarioThreads < 1 {
+		scenarioThreads = 1
+	}
+	s.maxThreads = symbolThreads
+	s.scenarioThreads = scenarioThreads
+	return s
+}
+
+// Slots bounds the amount of blocks simulated at once, a slot is held while a worker
+// evaluates a block so evaluators sharing slots interleave at block boundaries
+type Slots interface {
+	Acquire()
+	Release()
+}
+
+// SetSlots shares a worker budget with other evaluators
+func (s *Evaluator) SetSlots(slots Slots) *Evaluator {
+	s.slots = slots
+	return s
+}
+
+// SetPrefetchDepth sets how many blocks ahead data is fetched while a block is evaluated
+func (s *Evaluator) SetPrefetchDepth(depth int) *Evaluator {
+	s.prefetch = depth
+	return s
+}
+
 func (s *Evaluator) Metrics() *algo.Status {
 	return &s.metrics
 }
@@ -47,26 +114,43 @@ type EvalOptions struct {
 	Step       StepFunction
 	Resolution int64
 	Symbols    []string
+	// From skips history before the given unix timestamp, zero simulates from the on-board date
+	From int64
+	// Until truncates the simulation at the given unix timestamp, zero simulates up to now
+	Until int64
+	// Name of the evaluated strategy, used to detect cyclic dependencies
+	Name string
 }
 
-func NewEvaluator(opts EvalOptions) *Evaluator {
-	assets := make([]candlestick.AssetIdentifier, 0)
-	for _, symbol := range opts.Symbols {
-		// TODO: move this to candlestick lib
-		xs := strings.Split(symbol, ":")
-		assets = append(assets, candlestick.NewAssetIdentifier(xs[0], xs[1], xs[2]))
+// NewEvaluator resolves all requested symbols before any simulation starts,
+// unknown symbols are reported as kiosk.SymbolErrors
+func NewEvaluator(opts EvalOptions) (*Evaluator, error) {
+	assets, err := kiosk.ResolveSymbols(opts.Symbols)
+	if err != nil {
+		return nil, err
 	}
-	return &Evaluator{
-		step:       opts.Step,
-		symbols:    assets,
-		resolution: opts.Resolution,
-		maxThreads: runtime.NumCPU(),
-		metrics:    algo.Status{},
-		results:    nil,
+	chain := make([]string, 0)
+	if opts.Name != "" {
+		chain = append(chain, opts.Name)
 	}
+	return &Evaluator{
+		step:            opts.Step,
+		symbols:         assets,
+		resolution:      opts.Resolution,
+		from:            opts.From,
+		until:           opts.Until,
+		maxThreads:      runtime.NumCPU(),
+		prefetch:        2,
+		scenarioThreads: 1,
+		chain:           chain,
+		metrics:         algo.Status{},
+		results:         nil,
+	}, nil
 }
 
-func (s *Evaluator) Run(scenarios [][]float64, keys []string) *Evaluator {
+// Run simulates the scenarios over every symbol, it returns the first error of a symbol
+// after all symbols finished
+func (s *Evaluator) Run(scenarios [][]float64, keys []string) error {
 
 	// start timer
 	s.metrics.StartTime = time.Now().UTC().UnixMilli()
@@ -92,14 +176,14 @@ func (s *Evaluator) Run(scenarios [][]float64, keys []string) *Evaluator {
 		for i := range tasks {
 			task := tasks[i]
 			threads.Run(func() {
-				task.Simulate(s, scenarios, keys, results)
+				task.err = task.Simulate(s, scenarios, keys, results)
 			})
 		}
 		threads.Wait()
 	} else {
 		for i := range tasks {
 			task := tasks[i]
-			task.Simulate(s, scenarios, keys, results)
+			task.err = task.Simulate(s, scenarios, keys, results)
 		}
 	}
 
@@ -109,14 +193,22 @@ func (s *Evaluator) Run(scenarios [][]float64, keys []string) *Evaluator {
 	s.results = results.Data
 	s.metrics.Finished = true
 
-	return s
+	for _, task := range tasks {
+		if task.err != nil {
+			return fmt.Errorf("simulating %s failed: %w", task.symbol.ToString(), task.err)
+		}
+	}
+	return nil
 }
 
 type Task struct {
 	symbol candlestick.AssetIdentifier
+	err    error
 }
 
-func (s *Task) Simulate(sim *Evaluator, scenarios [][]float64, keys []string, results *ResultWithLock) {
+// Simulate evaluates the scenarios over a single symbol, it stops at the first block after
+// an algorithm could not be fetched
+func (s *Task) Simulate(sim *Evaluator, scenarios [][]float64, keys []string, results *ResultWithLock) error {
 
 	// provider for all scenarios
 	provider := kiosk.NewProvider(s.symbol, sim.resolution)
@@ -149,34 +241,96 @@ func (s *Task) Simulate(sim *Evaluator, scenarios [][]float64, keys []string, re
 		}
 	}
 
+	// continue cached or checkpointed scenarios after the last candle they simulated
+	resumed := make([]int64, len(scenarios))
+	if sim.stateful() {
+		for i := range scenarios {
+			if state := sim.takeState(s.symbol, keys, i, scenarios[i]); state != nil {
+				memories[i] = state.Memory
+				resultSet.Scenarios[i] = state.resume()
+				resumed[i] = state.LastTime
+			}
+		}
+	}
+
 	info := provider.Info()
+	if err := provider.Err(); err != nil {
+		return err
+	}
 
 	// iterate block per block, taking advantage of cached requests
 	// TODO: move this to candlestick lib
 	algoSupplier := kiosk.NewAlgorithmStore(s.symbol, sim.resolution)
+	if sim.dependencies != nil {
+		algoSupplier.SetResolver(sim.resolver(s.symbol))
+		if err := sim.prepare(s.symbol, provider); err != nil {
+			return err
+		}
+		defer sim.collect(provider, algoSupplier)
+	}
 	blockTimeSize := provider.Resolution() * candlestick.CandleSetSize
-	startBlock := info.OnBoardDate / blockTimeSize
-	currentBlock := time.Now().UTC().Unix() / blockTimeSize
-	for block := startBlock; block <= currentBlock; block++ {
+	endTime := time.Now().UTC().Unix()
+	if sim.until != 0 {
+		endTime = sim.until
+	}
+	if sim.stateful() {
+		// the open candle changes until it closes, it is never part of a cached state
+		closed := time.Now().UTC().Unix() - provider.Resolution()
+		if endTime > closed {
+			endTime = closed
+		}
+	}
+	startTime := info.OnBoardDate
+	if sim.from > startTime {
+		startTime = sim.from
+	}
 
-		// create data store for current block
-		prev := provider.NewDataStore(block - 1)
-		curr := provider.NewDataStore(block)
+	// skip blocks every scenario already simulated
+	firstTime := int64(math.MaxInt64)
+	for _, last := range resumed {
+		if last < firstTime {
+			firstTime = last
+		}
+	}
+	if firstTime < startTime || len(resumed) == 0 {
+		firstTime = startTime
+	}
+	startBlock := firstTime / blockTimeSize
+	currentBlock := endTime / blockTimeSize
+	prefetcher := kiosk.NewPrefetcher(provider, sim.prefetch, currentBlock)
+	defer prefetcher.Close()
+
+	// evaluate a range of scenarios over a block, returns the time of the last step
+	simulate := func(prev *kiosk.DataStore, curr *kiosk.DataStore, from int, to int) int64 {
+		if sim.slots != nil {
+			sim.slots.Acquire()
+			defer sim.slots.Release()
+		}
+		last := int64(0)
 
 		// iterate 5000 minute candles
 		for i := 0; i < 5000; i++ {
 
 			// check if market is open
 			candle := &curr.CandleSet(sim.resolution).Candles[i]
-			if candle.Missing {
+			if candle.Missing || candle.Time < startTime {
 				continue
 			}
+			if candle.Time > endTime {
+				break
+			}
+			last = candle.Time
 
 			// create data supplier for current time instance
 			ds := kiosk.NewSupplier(prev, curr, i, algoSupplier)
 
 			// iterate scenarios
-			for j := range scenarios {
+			for j := from; j < to; j++ {
+
+				// skip candles simulated by a previous evaluation
+				if candle.Time <= resumed[j] {
+					continue
+				}
 
 				// retrieve memory
 				mem := memories[j]
@@ -188,7 +342,65 @@ func (s *Task) Simulate(sim *Evaluator, scenarios [][]float64, keys []string, re
 				sim.step(&ds, res, mem, parameters[j])
 			}
 		}
+		return last
+	}
+
+	// scenarios are independent, shard them over workers sharing the same data stores
+	shards := sim.scenarioThreads
+	if shards > len(scenarios) {
+		shards = len(scenarios)
+	}
+	if shards < 1 {
+		shards = 1
+	}
+
+	for block := startBlock; block <= currentBlock; block++ {
+
+		// create data store for current block, upcoming blocks are fetched in the background
+		prev := prefetcher.Store(block - 1)
+		curr := prefetcher.Store(block)
+
+		var last int64
+		if shards == 1 {
+			last = simulate(prev, curr, 0, len(scenarios))
+		} else {
+			lastTimes := make([]int64, shards)
+			var wg sync.WaitGroup
+			for w := 0; w < shards; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					lastTimes[w] = simulate(prev, curr, w*len(scenarios)/shards, (w+1)*len(scenarios)/shards)
+				}(w)
+			}
+			wg.Wait()
+			for _, t := range lastTimes {
+				if t > last {
+					last = t
+				}
+			}
+		}
+		if last != 0 {
+			resultSet.LastTime = last
+		}
 
+		// results of strategies missing market data or an algorithm are incomplete
+		if err := provider.Err(); err != nil {
+			return err
+		}
+		if err := algoSupplier.Err(); err != nil {
+			return err
+		}
+	}
+	for _, last := range resumed {
+		if last > resultSet.LastTime {
+			resultSet.LastTime = last
+		}
 	}
 
+	// only complete runs are continued later
+	if sim.stateful() {
+		sim.putStates(s.symbol, keys, scenarios, resultSet, memories, resumed, provider.Charted())
+	}
+	return nil
 }
@@ -0,0 +1,268 @@
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"github.com/godoji/algocore/internal/kiosktest"
+	"github.com/godoji/algocore/internal/kiosktest/market"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"github.com/godoji/algocore/pkg/kiosk"
+	"github.com/northberg/candlestick"
+	"strings"
+	"testing"
+	"time"
+)
+
+func noopStep(chart env.MarketSupplier, term *algo.ResultHandler, mem *env.Memory, params env.Parameters) {
+}
+
+type crossMemory struct {
+	Above bool
+}
+
+// crossStep emits an event whenever the price crosses the threshold parameter
+func crossStep(chart env.MarketSupplier, res *algo.ResultHandler, mem *env.Memory, params env.Parameters) {
+	store, ok := mem.Read().(*crossMemory)
+	if !ok {
+		store = new(crossMemory)
+	}
+	above := chart.Price() > params.Get("threshold")
+	if above && !store.Above {
+		res.NewEvent("up")
+	} else if !above && store.Above {
+		res.NewEvent("down")
+	}
+	store.Above = above
+	mem.Store(store)
+}
+
+// crossOptions simulates a few blocks of hourly candles of the synthetic market
+func crossOptions(symbols int) EvalOptions {
+	kiosktest.Setup()
+	return EvalOptions{
+		Step:       crossStep,
+		Resolution: 3600,
+		Symbols:    kiosktest.Symbols[:symbols],
+		From:       kiosktest.OnBoard,
+		Until:      kiosktest.OnBoard + 3600*12000,
+	}
+}
+
+var crossScenarios = [][]float64{{95}, {100}, {105}, {110}}
+
+func sameResults(expected *algo.SymbolResultSet, actual *algo.SymbolResultSet, scenarios ...int) bool {
+	if expected.LastTime != actual.LastTime {
+		fmt.Printf("expected last time %d but got %d\n", expected.LastTime, actual.LastTime)
+		return false
+	}
+	for _, i := range scenarios {
+		if diff, ok := firstDifference(expected.Scenarios[i].Events, actual.Scenarios[i].Events); !ok {
+			fmt.Printf("scenario %d differs at %d\n", i, diff)
+			return false
+		}
+	}
+	return true
+}
+
+func TestShardedScenarios(t *testing.T) {
+
+	opts := crossOptions(1)
+	keys := []string{"threshold"}
+	serial, err := NewEvaluator(opts)
+	if err != nil {
+		panic(err)
+	}
+	if err = serial.KeepStates().Run(crossScenarios, keys); err != nil {
+		panic(err)
+	}
+	expected := serial.Results().Symbols[opts.Symbols[0]]
+	if len(expected.Scenarios[1].Events) == 0 {
+		fmt.Println("expected the price to cross the threshold")
+		t.FailNow()
+	}
+
+	sharded, err := NewEvaluator(opts)
+	if err != nil {
+		panic(err)
+	}
+	if err = sharded.SetScenarioThreads(2).Run(crossScenarios, keys); err != nil {
+		panic(err)
+	}
+	actual := sharded.Results().Symbols[opts.Symbols[0]]
+	if !sameResults(expected, actual, 0, 1, 2, 3) {
+		fmt.Println("expected sharded results to equal the serial run")
+		t.Fail()
+	}
+
+	// the first shard has nothing left to simulate when its scenarios continue after the run
+	states := serial.States()[opts.Symbols[0]]
+	resumed, err := NewEvaluator(opts)
+	if err != nil {
+		panic(err)
+	}
+	resumed.Resume(map[string][]*ScenarioState{opts.Symbols[0]: {states[0], states[1], nil, nil}})
+	if err = resumed.SetScenarioThreads(2).Run(crossScenarios, keys); err != nil {
+		panic(err)
+	}
+	actual = resumed.Results().Symbols[opts.Symbols[0]]
+	if !sameResults(expected, actual, 0, 1, 2, 3) {
+		fmt.Println("expected sharded results with a finished shard to equal the serial run")
+		t.Fail()
+	}
+}
+
+func graph(deps map[string][]Dependency) Dependencies {
+	return func(name string) (*LocalStrategy, bool) {
+		d, ok := deps[name]
+		if !ok {
+			return nil, false
+		}
+		return &LocalStrategy{Step: noopStep, Dependencies: d}, true
+	}
+}
+
+func TestDependencyOrder(t *testing.T) {
+
+	deps := map[string][]Dependency{
+		"a": {Algorithm("b", 1), Algorithm("c")},
+		"b": {Algorithm("c")},
+		"c": {Algorithm("d", 2), Indicator("sma", 60, 20)},
+	}
+	order, err := DependencyOrder("a", graph(deps))
+	if err != nil {
+		panic(err)
+	}
+
+	// algorithms come after the algorithms they use, indicators are not part of the order
+	names := make([]string, len(order))
+	for i, dep := range order {
+		names[i] = dep.Name
+	}
+	if strings.Join(names, ",") != "d,c,b" {
+		fmt.Printf("expected order d,c,b but got %s\n", strings.Join(names, ","))
+		t.Fail()
+	}
+
+	deps["c"] = append(deps["c"], Algorithm("a"))
+	if _, err = DependencyOrder("a", graph(deps)); err == nil || !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		fmt.Printf("expected cyclic dependency to be reported but got %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestDependencyCycle(t *testing.T) {
+
+	sim := &Evaluator{
+		chain: []string{"outer", "inner"},
+	}
+	sim.SetDependencies(graph(map[string][]Dependency{"outer": {}, "inner": {}}))
+
+	// evaluating a strategy which is already being evaluated never finishes
+	_, err := sim.resolve(candlestick.AssetIdentifier{}, "outer", nil)
+	if err == nil {
+		fmt.Println("expected cyclic dependency to be detected")
+		t.FailNow()
+	}
+	if !strings.Contains(err.Error(), "outer -> inner -> outer") {
+		fmt.Printf("expected dependency chain in error but got: %s\n", err.Error())
+		t.Fail()
+	}
+}
+
+func TestDependencyPanic(t *testing.T) {
+
+	deps := newDependencyState(graph(nil))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		defer func() { _ = recover() }()
+		_, _ = deps.memoise("broken", func() (*algo.ScenarioSet, error) {
+			close(started)
+			<-release
+			panic("broken strategy")
+		})
+	}()
+	<-started
+
+	// callers waiting for a dependency which panicked receive an error
+	waited := make(chan error)
+	go func() {
+		_, err := deps.memoise("broken", func() (*algo.ScenarioSet, error) { return nil, nil })
+		waited <- err
+	}()
+	close(release)
+	select {
+	case err := <-waited:
+		if err == nil || !strings.Contains(err.Error(), "panicked") {
+			fmt.Printf("expected the panic to be reported but got %v\n", err)
+			t.Fail()
+		}
+	case <-time.After(5 * time.Second):
+		fmt.Println("expected waiters of a panicked dependency to return")
+		t.Fail()
+	}
+}
+
+func TestMarketServiceOnly(t *testing.T) {
+
+	// candle only strategies run with only the market service configured
+	opts := crossOptions(1)
+	evaluator, err := NewEvaluator(opts)
+	if err != nil {
+		panic(err)
+	}
+	if err = evaluator.Run(crossScenarios, []string{"threshold"}); err != nil {
+		fmt.Printf("expected candle only strategy to run without other services but got %s\n", err.Error())
+		t.Fail()
+	}
+
+	// the missing algorithm service is reported once the strategy requests an algorithm
+	opts.Step = func(chart env.MarketSupplier, res *algo.ResultHandler, mem *env.Memory, params env.Parameters) {
+		if chart.Price() > 105 && chart.Algorithm("trend").HasEvents() {
+			res.NewEvent("trend")
+		}
+	}
+	evaluator, err = NewEvaluator(opts)
+	if err != nil {
+		panic(err)
+	}
+	err = evaluator.Run([][]float64{{}}, []string{})
+	if err == nil || !strings.Contains(err.Error(), "ALGO_URL not set") {
+		fmt.Printf("expected the missing algorithm service to fail the run but got %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestMarketFailure(t *testing.T) {
+
+	// candles of this resolution are not fetched by other tests, failed requests are not cached
+	opts := crossOptions(1)
+	opts.Resolution = 1800
+	opts.Symbols = kiosktest.Symbols[3:]
+	market.SetFailing(true)
+	defer func() {
+		market.SetFailing(false)
+		time.Sleep(kiosktest.BreakerCooldown)
+	}()
+
+	// failed fetches fail the run until the breaker rejects requests to the market
+	for i := 0; ; i++ {
+		evaluator, err := NewEvaluator(opts)
+		if err != nil {
+			panic(err)
+		}
+		err = evaluator.Run(crossScenarios, []string{"threshold"})
+		if err == nil {
+			fmt.Println("expected the failing market to fail the run")
+			t.FailNow()
+		}
+		if errors.Is(err, kiosk.ErrCircuitOpen) {
+			break
+		}
+		if i == 10 {
+			fmt.Printf("expected the circuit breaker to open but got %s\n", err.Error())
+			t.FailNow()
+		}
+	}
+}
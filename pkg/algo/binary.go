@@ -0,0 +1,519 @@
+package algo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// BinaryMediaType is negotiated through the Accept header to receive results in the binary format
+//
+// Every payload starts with the magic bytes "ALGO", a version byte (2) and a kind byte
+// (1 = ResultSet, 2 = ScenarioSet) followed by a single record.
+//
+// Integers are varints as used by protocol buffers, counts and lengths are unsigned and
+// timestamps are zigzag encoded signed varints. Floats are 8 byte little endian IEEE 754
+// values. Strings are a length followed by UTF-8 bytes. Optional values start with a byte,
+// 0 when absent and 1 when present.
+//
+// Records are a length followed by their fields in the order listed below, decoders skip
+// fields appended to a record by later revisions:
+//
+//	ResultSet         count, then per symbol in ascending order: string symbol, SymbolResultSet
+//	SymbolResultSet   int lastTime, count, optional ScenarioSet per scenario
+//	ScenarioSet       count, float per parameter, count, optional Event per event
+//	Event             int createdOn, int time, float price, string label, string icon,
+//	                  string color, optional Annotations
+//	Annotations       count, optional Point per point, count, optional Segment per segment
+//	Point             string text, int time, float price, string icon, string color
+//	Segment           int timeFrom, int timeEnd, float priceBegin, float priceEnd, string style,
+//	                  string color
+const BinaryMediaType = "application/vnd.algocore.v2+binary"
+
+const (
+	binaryVersion     = 2
+	kindResultSet     = 1
+	kindScenarioSet   = 2
+	maxBinaryLength   = 1 << 30
+	binaryMagicLength = 4
+	// maxPrealloc bounds the elements allocated from a count before they are read
+	maxPrealloc = 1024
+)
+
+var binaryMagic = []byte("ALGO")
+
+var ErrBinaryFormat = errors.New("invalid binary result format")
+
+func EncodeResultSet(w io.Writer, set *ResultSet) error {
+	symbols := make([]string, 0, len(set.Symbols))
+	for symbol := range set.Symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return encode(w, kindResultSet, func(e *encoder) {
+		e.record(func() {
+			e.uint(uint64(len(symbols)))
+			for _, symbol := range symbols {
+				e.string(symbol)
+				e.symbolResultSet(set.Symbols[symbol])
+			}
+		})
+	})
+}
+
+func EncodeScenarioSet(w io.Writer, set *ScenarioSet) error {
+	return encode(w, kindScenarioSet, func(e *encoder) { e.scenarioSet(set) })
+}
+
+func DecodeResultSet(r io.Reader) (*ResultSet, error) {
+	d, err := newDecoder(r, kindResultSet)
+	if err != nil {
+		return nil, err
+	}
+	set := &ResultSet{Symbols: make(map[string]*SymbolResultSet)}
+	err = d.record(func(d *decoder) {
+		n := d.count()
+		for i := 0; i < n && d.err == nil; i++ {
+			symbol := d.string()
+			set.Symbols[symbol] = d.symbolResultSet()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+func DecodeScenarioSet(r io.Reader) (*ScenarioSet, error) {
+	d, err := newDecoder(r, kindScenarioSet)
+	if err != nil {
+		return nil, err
+	}
+	var set *ScenarioSet
+	if err = d.record(func(d *decoder) { set = d.scenarioSetFields() }); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// encoder streams a payload in two passes over the same fields, the first pass only measures
+// the length of every record and the second writes the records with the measured lengths
+type encoder struct {
+	w       *bufio.Writer
+	n       uint64
+	lengths []uint64
+	next    int
+	scratch [binary.MaxVarintLen64]byte
+}
+
+// encode writes the header followed by the fields written by payload
+func encode(w io.Writer, kind byte, payload func(e *encoder)) error {
+	e := new(encoder)
+	payload(e)
+	e.w = bufio.NewWriter(w)
+	e.write(binaryMagic)
+	e.write([]byte{binaryVersion, kind})
+	payload(e)
+	return e.w.Flush()
+}
+
+func (e *encoder) write(p []byte) {
+	if e.w == nil {
+		e.n += uint64(len(p))
+		return
+	}
+	// errors are kept by the writer and returned when flushing
+	_, _ = e.w.Write(p)
+}
+
+// record writes the fields of fields prefixed by their length
+func (e *encoder) record(fields func()) {
+	if e.w != nil {
+		e.uint(e.lengths[e.next])
+		e.next++
+		fields()
+		return
+	}
+	i := len(e.lengths)
+	e.lengths = append(e.lengths, 0)
+	begin := e.n
+	fields()
+	e.lengths[i] = e.n - begin
+	e.uint(e.lengths[i])
+}
+
+func (e *encoder) uint(v uint64) {
+	n := binary.PutUvarint(e.scratch[:], v)
+	e.write(e.scratch[:n])
+}
+
+func (e *encoder) int(v int64) {
+	n := binary.PutVarint(e.scratch[:], v)
+	e.write(e.scratch[:n])
+}
+
+func (e *encoder) float(v float64) {
+	binary.LittleEndian.PutUint64(e.scratch[:8], math.Float64bits(v))
+	e.write(e.scratch[:8])
+}
+
+func (e *encoder) string(v string) {
+	e.uint(uint64(len(v)))
+	if e.w == nil {
+		e.n += uint64(len(v))
+		return
+	}
+	_, _ = e.w.WriteString(v)
+}
+
+func (e *encoder) present(ok bool) bool {
+	if ok {
+		e.write([]byte{1})
+	} else {
+		e.write([]byte{0})
+	}
+	return ok
+}
+
+func (e *encoder) symbolResultSet(set *SymbolResultSet) {
+	e.record(func() {
+		e.int(set.LastTime)
+		e.uint(uint64(len(set.Scenarios)))
+		for _, scenario := range set.Scenarios {
+			if e.present(scenario != nil) {
+				e.scenarioSet(scenario)
+			}
+		}
+	})
+}
+
+func (e *encoder) scenarioSet(set *ScenarioSet) {
+	e.record(func() {
+		e.uint(uint64(len(set.Parameters)))
+		for _, p := range set.Parameters {
+			e.float(p)
+		}
+		e.uint(uint64(len(set.Events)))
+		for _, event := range set.Events {
+			if e.present(event != nil) {
+				e.event(event)
+			}
+		}
+	})
+}
+
+func (e *encoder) event(event *Event) {
+	e.record(func() {
+		e.int(event.CreatedOn)
+		e.int(event.Time)
+		e.float(event.Price)
+		e.string(event.Label)
+		e.string(event.Icon)
+		e.string(event.Color)
+		if e.present(event.Annotations != nil) {
+			e.annotations(event.Annotations)
+		}
+	})
+}
+
+func (e *encoder) annotations(a *AnnotationCollection) {
+	e.record(func() {
+		e.uint(uint64(len(a.Points)))
+		for _, p := range a.Points {
+			if e.present(p != nil) {
+				e.record(func() {
+					e.string(p.Text)
+					e.int(p.Time)
+					e.float(p.Price)
+					e.string(p.Icon)
+					e.string(p.Color)
+				})
+			}
+		}
+		e.uint(uint64(len(a.Segments)))
+		for _, s := range a.Segments {
+			if e.present(s != nil) {
+				e.record(func() {
+					e.int(s.TimeBegin)
+					e.int(s.TimeEnd)
+					e.float(s.PriceBegin)
+					e.float(s.PriceEnd)
+					e.string(s.Style)
+					e.string(s.Color)
+				})
+			}
+		}
+	})
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// recordReader reads the remaining n bytes of a record from its parent
+type recordReader struct {
+	r byteReader
+	n uint64
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	if uint64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.r.Read(p)
+	r.n -= uint64(n)
+	return n, err
+}
+
+func (r *recordReader) ReadByte() (byte, error) {
+	if r.n == 0 {
+		return 0, io.EOF
+	}
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.n--
+	}
+	return b, err
+}
+
+// decoder keeps the first error, reads after an error return zero values. Counts are bounded
+// by the remaining size of the record as every element takes at least a byte
+type decoder struct {
+	r   *recordReader
+	err error
+}
+
+func newDecoder(r io.Reader, kind byte) (*decoder, error) {
+	br := bufio.NewReader(r)
+	d := &decoder{r: &recordReader{r: br, n: maxBinaryLength}}
+	header := make([]byte, binaryMagicLength+2)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrBinaryFormat, err.Error())
+	}
+	if !bytes.Equal(header[:binaryMagicLength], binaryMagic) {
+		return nil, fmt.Errorf("%w: bad magic", ErrBinaryFormat)
+	}
+	if header[binaryMagicLength] != binaryVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBinaryFormat, header[binaryMagicLength])
+	}
+	if header[binaryMagicLength+1] != kind {
+		return nil, fmt.Errorf("%w: unexpected payload kind %d", ErrBinaryFormat, header[binaryMagicLength+1])
+	}
+	return d, nil
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrBinaryFormat, err.Error())
+	}
+}
+
+// record decodes the fields of a record from the parent and skips fields unknown to this revision
+func (d *decoder) record(fields func(d *decoder)) error {
+	n := d.uint()
+	if d.err != nil {
+		return d.err
+	}
+	if n > d.r.n {
+		d.fail(fmt.Errorf("record of %d bytes", n))
+		return d.err
+	}
+	inner := &decoder{r: &recordReader{r: d.r, n: n}}
+	fields(inner)
+	if inner.err != nil {
+		d.err = inner.err
+		return d.err
+	}
+	if _, err := io.Copy(io.Discard, inner.r); err != nil {
+		d.fail(err)
+	} else if inner.r.n != 0 {
+		d.fail(io.ErrUnexpectedEOF)
+	}
+	return d.err
+}
+
+func (d *decoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return v
+}
+
+func (d *decoder) count() int {
+	v := d.uint()
+	if v > d.r.n {
+		d.fail(fmt.Errorf("count of %d", v))
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) int() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(d.r)
+	if err != nil {
+		d.fail(err)
+	}
+	return v
+}
+
+func (d *decoder) float() float64 {
+	if d.err != nil {
+		return 0
+	}
+	var b [8]byte
+	if _, err := io.ReadFull(d.r, b[:]); err != nil {
+		d.fail(err)
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b[:]))
+}
+
+func (d *decoder) string() string {
+	n := d.count()
+	if d.err != nil {
+		return ""
+	}
+	var b strings.Builder
+	if _, err := io.CopyN(&b, d.r, int64(n)); err != nil {
+		d.fail(err)
+		return ""
+	}
+	return b.String()
+}
+
+// prealloc is the capacity allocated for a count, larger slices grow as elements are read
+func prealloc(n int) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return n
+}
+
+func (d *decoder) present() bool {
+	if d.err != nil {
+		return false
+	}
+	b, err := d.r.ReadByte()
+	if err != nil {
+		d.fail(err)
+		return false
+	}
+	return b == 1
+}
+
+func (d *decoder) symbolResultSet() *SymbolResultSet {
+	set := new(SymbolResultSet)
+	_ = d.record(func(d *decoder) {
+		set.LastTime = d.int()
+		n := d.count()
+		set.Scenarios = make([]*ScenarioSet, 0, prealloc(n))
+		for i := 0; i < n && d.err == nil; i++ {
+			var scenario *ScenarioSet
+			if d.present() {
+				scenario = d.scenarioSet()
+			}
+			set.Scenarios = append(set.Scenarios, scenario)
+		}
+	})
+	return set
+}
+
+func (d *decoder) scenarioSet() *ScenarioSet {
+	var set *ScenarioSet
+	_ = d.record(func(d *decoder) { set = d.scenarioSetFields() })
+	return set
+}
+
+func (d *decoder) scenarioSetFields() *ScenarioSet {
+	set := new(ScenarioSet)
+	n := d.count()
+	set.Parameters = make([]float64, 0, prealloc(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		set.Parameters = append(set.Parameters, d.float())
+	}
+	n = d.count()
+	set.Events = make([]*Event, 0, prealloc(n))
+	for i := 0; i < n && d.err == nil; i++ {
+		var event *Event
+		if d.present() {
+			event = d.event()
+		}
+		set.Events = append(set.Events, event)
+	}
+	return set
+}
+
+func (d *decoder) event() *Event {
+	event := new(Event)
+	_ = d.record(func(d *decoder) {
+		event.CreatedOn = d.int()
+		event.Time = d.int()
+		event.Price = d.float()
+		event.Label = d.string()
+		event.Icon = d.string()
+		event.Color = d.string()
+		if d.present() {
+			event.Annotations = d.annotations()
+		}
+	})
+	return event
+}
+
+func (d *decoder) annotations() *AnnotationCollection {
+	a := new(AnnotationCollection)
+	_ = d.record(func(d *decoder) {
+		n := d.count()
+		a.Points = make([]*PointAnnotation, 0, prealloc(n))
+		for i := 0; i < n && d.err == nil; i++ {
+			if !d.present() {
+				a.Points = append(a.Points, nil)
+				continue
+			}
+			p := new(PointAnnotation)
+			_ = d.record(func(d *decoder) {
+				p.Text = d.string()
+				p.Time = d.int()
+				p.Price = d.float()
+				p.Icon = d.string()
+				p.Color = d.string()
+			})
+			a.Points = append(a.Points, p)
+		}
+		n = d.count()
+		a.Segments = make([]*SegmentAnnotation, 0, prealloc(n))
+		for i := 0; i < n && d.err == nil; i++ {
+			if !d.present() {
+				a.Segments = append(a.Segments, nil)
+				continue
+			}
+			s := new(SegmentAnnotation)
+			_ = d.record(func(d *decoder) {
+				s.TimeBegin = d.int()
+				s.TimeEnd = d.int()
+				s.PriceBegin = d.float()
+				s.PriceEnd = d.float()
+				s.Style = d.string()
+				s.Color = d.string()
+			})
+			a.Segments = append(a.Segments, s)
+		}
+	})
+	return a
+}
@@ -0,0 +1,124 @@
+package algo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestBinaryResultSet(t *testing.T) {
+
+	set := &ResultSet{Symbols: map[string]*SymbolResultSet{
+		"A:B:C": {
+			LastTime: 1660000000,
+			Scenarios: []*ScenarioSet{
+				{
+					Parameters: []float64{7, 0.5},
+					Events: []*Event{
+						{CreatedOn: 1650000000, Time: 1649990000, Price: 12.5, Label: "high", Icon: "up", Color: "green"},
+						{CreatedOn: 1650000060, Time: -60, Price: -1, Annotations: &AnnotationCollection{
+							Points:   []*PointAnnotation{{Text: "entry", Time: 1, Price: 2, Icon: "dot", Color: "red"}},
+							Segments: []*SegmentAnnotation{{TimeBegin: 1, TimeEnd: 2, PriceBegin: 3, PriceEnd: 4, Style: "dashed", Color: "blue"}},
+						}},
+					},
+				},
+				nil,
+			},
+		},
+		"A:B:D": {Scenarios: []*ScenarioSet{{Parameters: []float64{}, Events: []*Event{}}}},
+	}}
+
+	buf := new(bytes.Buffer)
+	if err := EncodeResultSet(buf, set); err != nil {
+		panic(err)
+	}
+	decoded, err := DecodeResultSet(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		panic(err)
+	}
+	if !reflect.DeepEqual(set, decoded) {
+		fmt.Println("decoded result set differs from the encoded one")
+		t.Fail()
+	}
+
+	// truncated payloads are rejected instead of decoded partially
+	_, err = DecodeResultSet(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	if !errors.Is(err, ErrBinaryFormat) {
+		fmt.Printf("expected format error for truncated payload but got %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestBinarySkipsUnknownFields(t *testing.T) {
+
+	// a later revision appending a field to the scenario record
+	buf := new(bytes.Buffer)
+	err := encode(buf, kindScenarioSet, func(e *encoder) {
+		e.record(func() {
+			e.uint(1)
+			e.float(3)
+			e.uint(0)
+			e.string("added in a later revision")
+		})
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	set, err := DecodeScenarioSet(buf)
+	if err != nil {
+		panic(err)
+	}
+	if len(set.Parameters) != 1 || set.Parameters[0] != 3 || len(set.Events) != 0 {
+		fmt.Printf("unexpected scenario %+v\n", *set)
+		t.Fail()
+	}
+}
+
+func TestBinaryDeclaredLengths(t *testing.T) {
+
+	// lengths and counts larger than the payload fail without allocating for them
+	payloads := map[string]func(e *encoder){
+		"record": func(e *encoder) { e.uint(maxBinaryLength) },
+		"string": func(e *encoder) {
+			e.uint(maxBinaryLength)
+			e.uint(1)
+			e.uint(maxBinaryLength - 16)
+		},
+		"count": func(e *encoder) {
+			e.uint(maxBinaryLength)
+			e.uint(maxBinaryLength - 16)
+		},
+	}
+	for name, payload := range payloads {
+		buf := new(bytes.Buffer)
+		if err := encode(buf, kindResultSet, payload); err != nil {
+			panic(err)
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := DecodeResultSet(buf)
+		runtime.ReadMemStats(&after)
+		if !errors.Is(err, ErrBinaryFormat) {
+			fmt.Printf("expected format error for oversized %s but got %v\n", name, err)
+			t.Fail()
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			fmt.Printf("decoding an oversized %s allocated %d bytes\n", name, allocated)
+			t.Fail()
+		}
+	}
+
+	// a top level record beyond the cap is rejected
+	buf := new(bytes.Buffer)
+	if err := encode(buf, kindResultSet, func(e *encoder) { e.uint(maxBinaryLength + 1) }); err != nil {
+		panic(err)
+	}
+	if _, err := DecodeResultSet(buf); !errors.Is(err, ErrBinaryFormat) {
+		fmt.Printf("expected format error for a record beyond the cap but got %v\n", err)
+		t.Fail()
+	}
+}
@@ -0,0 +1,191 @@
+package algo
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+const (
+	CSVMediaType     = "text/csv"
+	ParquetMediaType = "application/vnd.apache.parquet"
+)
+
+// names of the tables a result set flattens into
+const (
+	EventsTable     = "events"
+	PointsTable     = "points"
+	SegmentsTable   = "segments"
+	ParametersTable = "parameters"
+)
+
+type ColumnType int
+
+const (
+	IntColumn ColumnType = iota
+	FloatColumn
+	StringColumn
+)
+
+type Column struct {
+	Name string
+	Type ColumnType
+}
+
+func (c Column) unexpected(v interface{}) error {
+	return fmt.Errorf("unexpected value %v of type %T in column %s", v, v, c.Name)
+}
+
+// Table is a flat table of results, row values are int64, float64 or string according to the
+// type of their column
+type Table struct {
+	Name    string
+	Columns []Column
+	Rows    [][]interface{}
+}
+
+var tableColumns = map[string][]Column{
+	EventsTable: {
+		{"symbol", StringColumn}, {"scenario", IntColumn}, {"event", IntColumn},
+		{"created_on", IntColumn}, {"time", IntColumn}, {"price", FloatColumn},
+		{"label", StringColumn}, {"icon", StringColumn}, {"color", StringColumn},
+	},
+	PointsTable: {
+		{"symbol", StringColumn}, {"scenario", IntColumn}, {"event", IntColumn}, {"point", IntColumn},
+		{"text", StringColumn}, {"time", IntColumn}, {"price", FloatColumn},
+		{"icon", StringColumn}, {"color", StringColumn},
+	},
+	SegmentsTable: {
+		{"symbol", StringColumn}, {"scenario", IntColumn}, {"event", IntColumn}, {"segment", IntColumn},
+		{"time_begin", IntColumn}, {"time_end", IntColumn}, {"price_begin", FloatColumn},
+		{"price_end", FloatColumn}, {"style", StringColumn}, {"color", StringColumn},
+	},
+	ParametersTable: {
+		{"symbol", StringColumn}, {"scenario", IntColumn}, {"parameter", IntColumn}, {"value", FloatColumn},
+	},
+}
+
+// TableNames lists the tables a result set flattens into
+func TableNames() []string {
+	return []string{EventsTable, PointsTable, SegmentsTable, ParametersTable}
+}
+
+func newTable(name string) *Table {
+	return &Table{Name: name, Columns: tableColumns[name], Rows: make([][]interface{}, 0)}
+}
+
+// Flatten splits a result set into tidy tables keyed by symbol and scenario index, events are
+// keyed by their index within the scenario and annotations by the index of their event
+func Flatten(set *ResultSet) map[string]*Table {
+
+	tables := make(map[string]*Table)
+	for _, name := range TableNames() {
+		tables[name] = newTable(name)
+	}
+	events, points, segments, parameters := tables[EventsTable], tables[PointsTable], tables[SegmentsTable], tables[ParametersTable]
+
+	symbols := make([]string, 0, len(set.Symbols))
+	for symbol := range set.Symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	for _, symbol := range symbols {
+		for i, scenario := range set.Symbols[symbol].Scenarios {
+			if scenario == nil {
+				continue
+			}
+			index := int64(i)
+			for j, p := range scenario.Parameters {
+				parameters.Rows = append(parameters.Rows, []interface{}{symbol, index, int64(j), p})
+			}
+			for j, e := range scenario.Events {
+				if e == nil {
+					continue
+				}
+				event := int64(j)
+				events.Rows = append(events.Rows, []interface{}{
+					symbol, index, event, e.CreatedOn, e.Time, e.Price, e.Label, e.Icon, e.Color,
+				})
+				if e.Annotations == nil {
+					continue
+				}
+				for k, p := range e.Annotations.Points {
+					if p != nil {
+						points.Rows = append(points.Rows, []interface{}{
+							symbol, index, event, int64(k), p.Text, p.Time, p.Price, p.Icon, p.Color,
+						})
+					}
+				}
+				for k, s := range e.Annotations.Segments {
+					if s != nil {
+						segments.Rows = append(segments.Rows, []interface{}{
+							symbol, index, event, int64(k), s.TimeBegin, s.TimeEnd, s.PriceBegin, s.PriceEnd, s.Style, s.Color,
+						})
+					}
+				}
+			}
+		}
+	}
+
+	return tables
+}
+
+// FlattenTable flattens a result set into a single table
+func FlattenTable(set *ResultSet, name string) (*Table, error) {
+	if _, ok := tableColumns[name]; !ok {
+		return nil, fmt.Errorf("unknown table %s", name)
+	}
+	return Flatten(set)[name], nil
+}
+
+// WriteCSV writes the table with a header row
+func (t *Table) WriteCSV(w io.Writer) error {
+	out := csv.NewWriter(w)
+	record := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		record[i] = c.Name
+	}
+	if err := out.Write(record); err != nil {
+		return err
+	}
+	for _, row := range t.Rows {
+		for i, v := range row {
+			switch v := v.(type) {
+			case int64:
+				record[i] = strconv.FormatInt(v, 10)
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'g', -1, 64)
+			case string:
+				record[i] = v
+			default:
+				return t.Columns[i].unexpected(v)
+			}
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// ExportCSV writes one table of a result set as CSV
+func ExportCSV(w io.Writer, set *ResultSet, table string) error {
+	t, err := FlattenTable(set, table)
+	if err != nil {
+		return err
+	}
+	return t.WriteCSV(w)
+}
+
+// ExportParquet writes one table of a result set as a Parquet file
+func ExportParquet(w io.Writer, set *ResultSet, table string) error {
+	t, err := FlattenTable(set, table)
+	if err != nil {
+		return err
+	}
+	return t.WriteParquet(w)
+}
@@ -0,0 +1,219 @@
+package algo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func exportFixture() *ResultSet {
+	return &ResultSet{Symbols: map[string]*SymbolResultSet{
+		"A:B:D": {Scenarios: []*ScenarioSet{{Parameters: []float64{3}, Events: []*Event{}}}},
+		"A:B:C": {Scenarios: []*ScenarioSet{
+			nil,
+			{
+				Parameters: []float64{7, 0.5},
+				Events: []*Event{
+					{CreatedOn: 120, Time: 60, Price: 12.5, Label: "high, low", Icon: "up", Color: "green"},
+					{CreatedOn: 180, Time: 180, Price: -1, Label: "low", Annotations: &AnnotationCollection{
+						Points:   []*PointAnnotation{{Text: "entry", Time: 1, Price: 2, Icon: "dot", Color: "red"}},
+						Segments: []*SegmentAnnotation{{TimeBegin: 1, TimeEnd: 2, PriceBegin: 3, PriceEnd: 4, Style: "dashed", Color: "blue"}},
+					}},
+				},
+			},
+		}},
+	}}
+}
+
+func TestFlatten(t *testing.T) {
+
+	tables := Flatten(exportFixture())
+
+	expected := map[string][][]interface{}{
+		EventsTable: {
+			{"A:B:C", int64(1), int64(0), int64(120), int64(60), 12.5, "high, low", "up", "green"},
+			{"A:B:C", int64(1), int64(1), int64(180), int64(180), -1.0, "low", "", ""},
+		},
+		PointsTable: {
+			{"A:B:C", int64(1), int64(1), int64(0), "entry", int64(1), 2.0, "dot", "red"},
+		},
+		SegmentsTable: {
+			{"A:B:C", int64(1), int64(1), int64(0), int64(1), int64(2), 3.0, 4.0, "dashed", "blue"},
+		},
+		ParametersTable: {
+			{"A:B:C", int64(1), int64(0), 7.0},
+			{"A:B:C", int64(1), int64(1), 0.5},
+			{"A:B:D", int64(0), int64(0), 3.0},
+		},
+	}
+	for name, rows := range expected {
+		if !reflect.DeepEqual(tables[name].Rows, rows) {
+			fmt.Printf("unexpected rows in %s table: %v\n", name, tables[name].Rows)
+			t.Fail()
+		}
+	}
+
+	if _, err := FlattenTable(exportFixture(), "trades"); err == nil {
+		fmt.Println("expected error for unknown table")
+		t.Fail()
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+
+	buf := new(bytes.Buffer)
+	if err := ExportCSV(buf, exportFixture(), EventsTable); err != nil {
+		panic(err)
+	}
+	records, err := csv.NewReader(buf).ReadAll()
+	if err != nil {
+		panic(err)
+	}
+	expected := [][]string{
+		{"symbol", "scenario", "event", "created_on", "time", "price", "label", "icon", "color"},
+		{"A:B:C", "1", "0", "120", "60", "12.5", "high, low", "up", "green"},
+		{"A:B:C", "1", "1", "180", "180", "-1", "low", "", ""},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		fmt.Printf("unexpected csv records: %v\n", records)
+		t.Fail()
+	}
+}
+
+// thriftReader decodes Thrift compact structs into maps of field ids to values
+type thriftReader struct {
+	r *bytes.Reader
+}
+
+func (t *thriftReader) structFields() map[int16]interface{} {
+	fields := make(map[int16]interface{})
+	last := int16(0)
+	for {
+		b, err := t.r.ReadByte()
+		if err != nil {
+			panic(err)
+		}
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			v, _ := binary.ReadVarint(t.r)
+			id = int16(v)
+		}
+		last = id
+		fields[id] = t.value(b & 0x0f)
+	}
+}
+
+func (t *thriftReader) value(kind byte) interface{} {
+	switch kind {
+	case thriftI32, thriftI64:
+		v, _ := binary.ReadVarint(t.r)
+		return v
+	case thriftBinary:
+		n, _ := binary.ReadUvarint(t.r)
+		b := make([]byte, n)
+		_, _ = t.r.Read(b)
+		return string(b)
+	case thriftList:
+		header, _ := t.r.ReadByte()
+		size := uint64(header >> 4)
+		if size == 15 {
+			size, _ = binary.ReadUvarint(t.r)
+		}
+		values := make([]interface{}, size)
+		for i := range values {
+			values[i] = t.value(header & 0x0f)
+		}
+		return values
+	case thriftStruct:
+		return t.structFields()
+	}
+	panic(fmt.Sprintf("unexpected thrift type %d", kind))
+}
+
+func TestExportParquet(t *testing.T) {
+
+	buf := new(bytes.Buffer)
+	if err := ExportParquet(buf, exportFixture(), EventsTable); err != nil {
+		panic(err)
+	}
+	file := buf.Bytes()
+	if !bytes.Equal(file[:4], parquetMagic) || !bytes.Equal(file[len(file)-4:], parquetMagic) {
+		fmt.Println("missing parquet magic")
+		t.FailNow()
+	}
+
+	length := binary.LittleEndian.Uint32(file[len(file)-8:])
+	footer := file[len(file)-8-int(length) : len(file)-8]
+	meta := (&thriftReader{r: bytes.NewReader(footer)}).structFields()
+
+	if meta[3] != int64(2) {
+		fmt.Printf("expected 2 rows but got %v\n", meta[3])
+		t.Fail()
+	}
+	schema := meta[2].([]interface{})
+	names := make([]string, 0)
+	for _, element := range schema[1:] {
+		names = append(names, element.(map[int16]interface{})[4].(string))
+	}
+	if !reflect.DeepEqual(names, []string{"symbol", "scenario", "event", "created_on", "time", "price", "label", "icon", "color"}) {
+		fmt.Printf("unexpected schema: %v\n", names)
+		t.Fail()
+	}
+
+	// read back the plain encoded labels of the first row group
+	group := meta[4].([]interface{})[0].(map[int16]interface{})
+	column := group[1].([]interface{})[6].(map[int16]interface{})[3].(map[int16]interface{})
+	page := bytes.NewReader(file[column[9].(int64):])
+	header := (&thriftReader{r: page}).structFields()
+	values := make([]byte, header[3].(int64))
+	_, _ = page.Read(values)
+	labels := make([]string, 0)
+	for len(values) > 0 {
+		n := binary.LittleEndian.Uint32(values)
+		labels = append(labels, string(values[4:4+n]))
+		values = values[4+n:]
+	}
+	if !reflect.DeepEqual(labels, []string{"high, low", "low"}) {
+		fmt.Printf("unexpected labels: %v\n", labels)
+		t.Fail()
+	}
+}
+
+func TestExportParquetFixture(t *testing.T) {
+
+	// the fixture was read back with the reader of github.com/xitongsys/parquet-go v1.6.2, which
+	// returned the schema and every value of the events table of exportFixture
+	expected, err := os.ReadFile("testdata/events.parquet")
+	if err != nil {
+		panic(err)
+	}
+	buf := new(bytes.Buffer)
+	if err = ExportParquet(buf, exportFixture(), EventsTable); err != nil {
+		panic(err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		fmt.Println("parquet file differs from the verified fixture")
+		t.Fail()
+	}
+}
+
+func TestExportUnexpectedValue(t *testing.T) {
+
+	table := newTable(ParametersTable)
+	table.Rows = append(table.Rows, []interface{}{"A:B:C", int64(0), int64(0), 1})
+	if err := table.WriteParquet(new(bytes.Buffer)); err == nil {
+		fmt.Println("expected an error for an int value in a float column")
+		t.Fail()
+	}
+	if err := table.WriteCSV(new(bytes.Buffer)); err == nil {
+		fmt.Println("expected an error for an int value when writing csv")
+		t.Fail()
+	}
+}
@@ -6,6 +6,7 @@ type ResultSet struct {
 
 type SymbolResultSet struct {
 	Scenarios []*ScenarioSet `json:"scenarios"`
+	LastTime  int64          `json:"lastTime"`
 }
 
 type ScenarioSet struct {
@@ -0,0 +1,291 @@
+package algo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// WriteParquet writes the table as a Parquet file without compression. Every column is required
+// and PLAIN encoded, rows are split into row groups of parquetRowGroupSize rows with a single
+// data page per column. Strings are UTF-8 byte arrays, integers are INT64 and floats DOUBLE.
+//
+// The file metadata and page headers use the Thrift compact protocol as described by the
+// Parquet format specification, only the fields required by readers are written.
+func (t *Table) WriteParquet(w io.Writer) error {
+
+	out := &countingWriter{w: w}
+	if _, err := out.Write(parquetMagic); err != nil {
+		return err
+	}
+
+	groups := make([]*thriftWriter, 0)
+	for begin := 0; begin < len(t.Rows); begin += parquetRowGroupSize {
+		end := begin + parquetRowGroupSize
+		if end > len(t.Rows) {
+			end = len(t.Rows)
+		}
+		group, err := t.writeRowGroup(out, t.Rows[begin:end])
+		if err != nil {
+			return err
+		}
+		groups = append(groups, group)
+	}
+
+	// file metadata
+	meta := newThriftWriter()
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(t.Columns)+1)
+	meta.structBegin()
+	meta.binary(4, []byte("schema"))
+	meta.i32(5, int32(len(t.Columns)))
+	meta.structEnd()
+	for _, c := range t.Columns {
+		meta.structBegin()
+		meta.i32(1, c.Type.parquetType())
+		meta.i32(3, parquetRequired)
+		meta.binary(4, []byte(c.Name))
+		if c.Type == StringColumn {
+			meta.i32(6, parquetUTF8)
+		}
+		meta.structEnd()
+	}
+	meta.i64(3, int64(len(t.Rows)))
+	meta.list(4, thriftStruct, len(groups))
+	for _, group := range groups {
+		meta.raw(group.buf.Bytes())
+	}
+	meta.binary(6, []byte("algocore"))
+	meta.stop()
+
+	footer := meta.buf.Bytes()
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	for _, b := range [][]byte{footer, length[:], parquetMagic} {
+		if _, err := out.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRowGroup writes a data page per column and returns the encoded row group metadata
+func (t *Table) writeRowGroup(out *countingWriter, rows [][]interface{}) (*thriftWriter, error) {
+
+	group := newThriftWriter()
+	group.structBegin()
+	group.list(1, thriftStruct, len(t.Columns))
+
+	total := int64(0)
+	for i, c := range t.Columns {
+
+		values := new(bytes.Buffer)
+		var scratch [8]byte
+		for _, row := range rows {
+			switch v := row[i].(type) {
+			case int64:
+				if c.Type != IntColumn {
+					return nil, c.unexpected(v)
+				}
+				binary.LittleEndian.PutUint64(scratch[:], uint64(v))
+				values.Write(scratch[:8])
+			case float64:
+				if c.Type != FloatColumn {
+					return nil, c.unexpected(v)
+				}
+				binary.LittleEndian.PutUint64(scratch[:], math.Float64bits(v))
+				values.Write(scratch[:8])
+			case string:
+				if c.Type != StringColumn {
+					return nil, c.unexpected(v)
+				}
+				binary.LittleEndian.PutUint32(scratch[:], uint32(len(v)))
+				values.Write(scratch[:4])
+				values.WriteString(v)
+			default:
+				return nil, c.unexpected(v)
+			}
+		}
+
+		header := newThriftWriter()
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(values.Len()))
+		header.i32(3, int32(values.Len()))
+		header.structField(5)
+		header.i32(1, int32(len(rows)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.structEnd()
+		header.stop()
+
+		offset := out.n
+		if _, err := out.Write(header.buf.Bytes()); err != nil {
+			return nil, err
+		}
+		if _, err := out.Write(values.Bytes()); err != nil {
+			return nil, err
+		}
+		size := out.n - offset
+		total += size
+
+		// column chunk
+		group.structBegin()
+		group.i64(2, offset)
+		group.structField(3)
+		group.i32(1, c.Type.parquetType())
+		group.list(2, thriftI32, 1)
+		group.varint(parquetPlain)
+		group.list(3, thriftBinary, 1)
+		group.bytes([]byte(c.Name))
+		group.i32(4, parquetUncompressed)
+		group.i64(5, int64(len(rows)))
+		group.i64(6, size)
+		group.i64(7, size)
+		group.i64(9, offset)
+		group.structEnd()
+		group.structEnd()
+	}
+
+	group.i64(2, total)
+	group.i64(3, int64(len(rows)))
+	group.structEnd()
+	return group, nil
+}
+
+const parquetRowGroupSize = 1 << 16
+
+var parquetMagic = []byte("PAR1")
+
+// values of the Parquet format enums
+const (
+	parquetInt64        = 2
+	parquetDouble       = 5
+	parquetByteArray    = 6
+	parquetRequired     = 0
+	parquetUTF8         = 0
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+func (c ColumnType) parquetType() int32 {
+	switch c {
+	case FloatColumn:
+		return parquetDouble
+	case StringColumn:
+		return parquetByteArray
+	}
+	return parquetInt64
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol, field ids are written as deltas
+// to the previous field of the enclosing struct
+type thriftWriter struct {
+	buf  *bytes.Buffer
+	last []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{buf: new(bytes.Buffer), last: []int16{0}}
+}
+
+func (t *thriftWriter) field(id int16, kind byte) {
+	delta := id - t.last[len(t.last)-1]
+	if delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buf.WriteByte(kind)
+		t.varint(int64(id))
+	}
+	t.last[len(t.last)-1] = id
+}
+
+func (t *thriftWriter) varint(v int64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(scratch[:], v)
+	t.buf.Write(scratch[:n])
+}
+
+func (t *thriftWriter) uvarint(v uint64) {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], v)
+	t.buf.Write(scratch[:n])
+}
+
+func (t *thriftWriter) bytes(v []byte) {
+	t.uvarint(uint64(len(v)))
+	t.buf.Write(v)
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int16, v []byte) {
+	t.field(id, thriftBinary)
+	t.bytes(v)
+}
+
+// list writes the header of a list field, the elements follow without field headers
+func (t *thriftWriter) list(id int16, kind byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | kind)
+	} else {
+		t.buf.WriteByte(0xf0 | kind)
+		t.uvarint(uint64(size))
+	}
+}
+
+// structField starts a struct valued field, it is closed by structEnd
+func (t *thriftWriter) structField(id int16) {
+	t.field(id, thriftStruct)
+	t.structBegin()
+}
+
+// structBegin starts a struct element of a list, it is closed by structEnd
+func (t *thriftWriter) structBegin() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thriftWriter) structEnd() {
+	t.stop()
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+func (t *thriftWriter) raw(b []byte) {
+	t.buf.Write(b)
+}
@@ -0,0 +1,245 @@
+package bars
+
+import (
+	"fmt"
+	"github.com/northberg/candlestick"
+	"math"
+)
+
+// Builder turns a stream of completed candles into derived bars
+type Builder interface {
+	// Push consumes the next completed candle and returns any bars it completed
+	Push(c candlestick.Candle) []candlestick.Candle
+}
+
+// merge extends a bar with the range and volume of a candle
+func merge(bar *candlestick.Candle, c candlestick.Candle) {
+	bar.High = math.Max(bar.High, c.High)
+	bar.Low = math.Min(bar.Low, c.Low)
+	bar.Close = c.Close
+	bar.Volume += c.Volume
+}
+
+type HeikinAshi struct {
+	prev *candlestick.Candle
+}
+
+func NewHeikinAshi() *HeikinAshi {
+	return &HeikinAshi{}
+}
+
+func (b *HeikinAshi) Push(c candlestick.Candle) []candlestick.Candle {
+	bar := candlestick.Candle{
+		Time:   c.Time,
+		Close:  (c.Open + c.High + c.Low + c.Close) / 4,
+		Volume: c.Volume,
+	}
+	if b.prev == nil {
+		bar.Open = (c.Open + c.Close) / 2
+	} else {
+		bar.Open = (b.prev.Open + b.prev.Close) / 2
+	}
+	bar.High = math.Max(c.High, math.Max(bar.Open, bar.Close))
+	bar.Low = math.Min(c.Low, math.Min(bar.Open, bar.Close))
+	b.prev = &bar
+	return []candlestick.Candle{bar}
+}
+
+// Renko emits fixed size bricks, a reversal needs a move of two bricks
+type Renko struct {
+	size        float64
+	top         float64
+	bottom      float64
+	volume      float64
+	initialized bool
+}
+
+func NewRenko(size float64) *Renko {
+	return &Renko{size: size}
+}
+
+func (b *Renko) Push(c candlestick.Candle) []candlestick.Candle {
+	if !b.initialized {
+		b.top = c.Close
+		b.bottom = c.Close
+		b.initialized = true
+		return nil
+	}
+	b.volume += c.Volume
+	result := make([]candlestick.Candle, 0)
+	for c.Close >= b.top+b.size {
+		result = append(result, b.brick(c.Time, b.top, b.top+b.size))
+		b.bottom = b.top
+		b.top += b.size
+	}
+	for c.Close <= b.bottom-b.size {
+		result = append(result, b.brick(c.Time, b.bottom, b.bottom-b.size))
+		b.top = b.bottom
+		b.bottom -= b.size
+	}
+	return result
+}
+
+func (b *Renko) brick(ts int64, open float64, close float64) candlestick.Candle {
+	brick := candlestick.Candle{
+		Time:   ts,
+		Open:   open,
+		High:   math.Max(open, close),
+		Low:    math.Min(open, close),
+		Close:  close,
+		Volume: b.volume,
+	}
+	b.volume = 0
+	return brick
+}
+
+// RenkoATR derives the brick size from the average true range of the first candles
+type RenkoATR struct {
+	atr   Indicator
+	renko *Renko
+}
+
+func NewRenkoATR(period int) *RenkoATR {
+	return &RenkoATR{atr: NewATR(period)}
+}
+
+func (b *RenkoATR) Push(c candlestick.Candle) []candlestick.Candle {
+	if b.renko != nil {
+		return b.renko.Push(c)
+	}
+	if size, ok := b.atr.Push(c); ok && size > 0 {
+		b.renko = NewRenko(size)
+		b.renko.Push(c)
+	}
+	return nil
+}
+
+// Range emits a bar whenever its high to low range reaches the given size
+type Range struct {
+	size float64
+	bar  *candlestick.Candle
+}
+
+func NewRange(size float64) *Range {
+	return &Range{size: size}
+}
+
+func (b *Range) Push(c candlestick.Candle) []candlestick.Candle {
+	if b.bar == nil {
+		bar := c
+		b.bar = &bar
+	} else {
+		merge(b.bar, c)
+	}
+	if b.bar.High-b.bar.Low < b.size {
+		return nil
+	}
+	bar := *b.bar
+	b.bar = nil
+	return []candlestick.Candle{bar}
+}
+
+// Volume emits a bar whenever its accumulated volume reaches the given amount
+type Volume struct {
+	volume float64
+	bar    *candlestick.Candle
+}
+
+func NewVolume(volume float64) *Volume {
+	return &Volume{volume: volume}
+}
+
+func (b *Volume) Push(c candlestick.Candle) []candlestick.Candle {
+	if b.bar == nil {
+		bar := c
+		b.bar = &bar
+	} else {
+		merge(b.bar, c)
+	}
+	if b.bar.Volume < b.volume {
+		return nil
+	}
+	bar := *b.bar
+	b.bar = nil
+	return []candlestick.Candle{bar}
+}
+
+// Resampler aggregates candles into bars of a custom interval, aligned to the unix epoch shifted by an anchor
+type Resampler struct {
+	base     int64
+	interval int64
+	anchor   int64
+	bar      *candlestick.Candle
+}
+
+func NewResampler(base int64, interval int64, anchor int64) (*Resampler, error) {
+	if base <= 0 || interval <= 0 {
+		return nil, fmt.Errorf("intervals must be positive")
+	}
+	if interval%base != 0 || anchor%base != 0 {
+		return nil, fmt.Errorf("interval %d and anchor %d must be multiples of base interval %d", interval, anchor, base)
+	}
+	return &Resampler{base: base, interval: interval, anchor: anchor}, nil
+}
+
+// Start returns the opening time of the bar containing the given time
+func (b *Resampler) Start(ts int64) int64 {
+	offset := (ts - b.anchor) % b.interval
+	if offset < 0 {
+		offset += b.interval
+	}
+	return ts - offset
+}
+
+// Push adds a candle, bars are completed by their last candle or by the first candle after a gap
+func (b *Resampler) Push(c candlestick.Candle) []candlestick.Candle {
+	result := make([]candlestick.Candle, 0)
+	start := b.Start(c.Time)
+	if b.bar != nil && b.bar.Time != start {
+		result = append(result, *b.bar)
+		b.bar = nil
+	}
+	if b.bar == nil {
+		bar := c
+		bar.Time = start
+		b.bar = &bar
+	} else {
+		merge(b.bar, c)
+	}
+	if c.Time+b.base >= start+b.interval {
+		result = append(result, *b.bar)
+		b.bar = nil
+	}
+	return result
+}
+
+// Flush returns the incomplete bar that is still being aggregated
+func (b *Resampler) Flush() []candlestick.Candle {
+	if b.bar == nil {
+		return nil
+	}
+	bar := *b.bar
+	b.bar = nil
+	return []candlestick.Candle{bar}
+}
+
+// Resample aggregates consecutive candle sets of the base interval into bars of a custom interval,
+// missing or repeated candles are skipped and periods without any candle produce no bar
+func Resample(sets []*candlestick.CandleSet, base int64, interval int64, anchor int64) ([]candlestick.Candle, error) {
+	b, err := NewResampler(base, interval, anchor)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]candlestick.Candle, 0)
+	last := int64(math.MinInt64)
+	for _, set := range sets {
+		for _, c := range set.Candles {
+			if c.Missing || c.Time <= last {
+				continue
+			}
+			last = c.Time
+			result = append(result, b.Push(c)...)
+		}
+	}
+	return append(result, b.Flush()...), nil
+}
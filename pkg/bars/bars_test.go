@@ -0,0 +1,136 @@
+package bars
+
+import (
+	"fmt"
+	"github.com/northberg/candlestick"
+	"testing"
+)
+
+func candle(ts int64, open, high, low, close, volume float64) candlestick.Candle {
+	return candlestick.Candle{Time: ts, Open: open, High: high, Low: low, Close: close, Volume: volume}
+}
+
+func TestHeikinAshi(t *testing.T) {
+
+	b := NewHeikinAshi()
+	first := b.Push(candle(1, 10, 14, 8, 12, 1))[0]
+	second := b.Push(candle(2, 12, 16, 11, 15, 1))[0]
+
+	if first.Close != 11 || first.Open != 11 {
+		fmt.Printf("expected open and close %f but got %f and %f\n", 11.0, first.Open, first.Close)
+		t.Fail()
+	}
+	if second.Open != 11 || second.Close != 13.5 || second.High != 16 || second.Low != 11 {
+		fmt.Printf("unexpected second bar %+v\n", second)
+		t.Fail()
+	}
+}
+
+func TestRenko(t *testing.T) {
+
+	b := NewRenko(1)
+	b.Push(candle(1, 10, 10, 10, 10, 0))
+
+	if n := len(b.Push(candle(2, 10, 13.5, 10, 13.5, 0))); n != 3 {
+		fmt.Printf("expected %d up bricks but got %d\n", 3, n)
+		t.Fail()
+	}
+
+	// a single brick move down is not enough to reverse
+	if n := len(b.Push(candle(3, 13, 13, 12, 12, 0))); n != 0 {
+		fmt.Printf("expected no bricks but got %d\n", n)
+		t.Fail()
+	}
+
+	bricks := b.Push(candle(4, 12, 12, 11, 11, 0))
+	if len(bricks) != 1 || bricks[0].Open != 12 || bricks[0].Close != 11 {
+		fmt.Printf("expected a single down brick but got %+v\n", bricks)
+		t.Fail()
+	}
+}
+
+func TestVolume(t *testing.T) {
+
+	b := NewVolume(10)
+	b.Push(candle(1, 10, 11, 9, 10, 4))
+	b.Push(candle(2, 10, 12, 10, 11, 4))
+	bars := b.Push(candle(3, 11, 11, 8, 9, 4))
+
+	if len(bars) != 1 {
+		fmt.Printf("expected %d bar but got %d\n", 1, len(bars))
+		t.FailNow()
+	}
+	bar := bars[0]
+	if bar.Time != 1 || bar.Open != 10 || bar.High != 12 || bar.Low != 8 || bar.Close != 9 || bar.Volume != 12 {
+		fmt.Printf("unexpected volume bar %+v\n", bar)
+		t.Fail()
+	}
+}
+
+func TestEMA(t *testing.T) {
+
+	ema := NewEMA(3)
+	for i, v := range []float64{1, 2, 3} {
+		value, ok := ema.Push(candle(int64(i), v, v, v, v, 0))
+		if ok != (i == 2) {
+			fmt.Printf("unexpected warm up state at %d\n", i)
+			t.Fail()
+		}
+		if ok && value != 2 {
+			fmt.Printf("expected %f but got %f\n", 2.0, value)
+			t.Fail()
+		}
+	}
+	if value, _ := ema.Push(candle(3, 4, 4, 4, 4, 0)); value != 3 {
+		fmt.Printf("expected %f but got %f\n", 3.0, value)
+		t.Fail()
+	}
+}
+
+func TestResampleWeekly(t *testing.T) {
+
+	day := int64(24 * 60 * 60)
+	monday := 4 * day
+
+	// two weeks of daily candles without weekends, and a missing candle
+	set := &candlestick.CandleSet{Candles: make([]candlestick.Candle, 0)}
+	for i := int64(0); i < 12; i++ {
+		c := candle(monday+i*day, float64(i), float64(i)+1, float64(i)-1, float64(i), 1)
+		c.Missing = i%7 >= 5 || i == 8
+		set.Candles = append(set.Candles, c)
+	}
+
+	result, err := Resample([]*candlestick.CandleSet{set}, day, 7*day, monday)
+	if err != nil {
+		panic(err)
+	}
+	if len(result) != 2 {
+		fmt.Printf("expected %d bars but got %d\n", 2, len(result))
+		t.FailNow()
+	}
+	if result[0].Time != monday || result[0].Open != 0 || result[0].Close != 4 || result[0].High != 5 || result[0].Volume != 5 {
+		fmt.Printf("unexpected first week %+v\n", result[0])
+		t.Fail()
+	}
+	if result[1].Time != monday+7*day || result[1].Open != 7 || result[1].Low != 6 || result[1].Volume != 4 {
+		fmt.Printf("unexpected second week %+v\n", result[1])
+		t.Fail()
+	}
+}
+
+func TestResamplerAlignment(t *testing.T) {
+
+	if _, err := NewResampler(3600, 5400, 0); err == nil {
+		fmt.Println("expected 90 minutes not to be built from hourly candles")
+		t.Fail()
+	}
+
+	b, err := NewResampler(1800, 5400, 0)
+	if err != nil {
+		panic(err)
+	}
+	if start := b.Start(5400*3 + 1800); start != 5400*3 {
+		fmt.Printf("expected start %d but got %d\n", 5400*3, start)
+		t.Fail()
+	}
+}
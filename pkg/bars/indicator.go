@@ -0,0 +1,137 @@
+package bars
+
+import (
+	"fmt"
+	"github.com/northberg/candlestick"
+	"math"
+)
+
+// Indicator is computed locally over a series of bars
+type Indicator interface {
+	// Push consumes the next bar and returns the indicator value, false while warming up
+	Push(c candlestick.Candle) (float64, bool)
+}
+
+// NewIndicator creates a local indicator by name, matching the names used by the indicator service
+func NewIndicator(name string, params ...int) (Indicator, error) {
+	if len(params) != 1 || params[0] < 1 {
+		return nil, fmt.Errorf("indicator \"%s\" expects a single positive period", name)
+	}
+	switch name {
+	case "sma":
+		return NewSMA(params[0]), nil
+	case "ema":
+		return NewEMA(params[0]), nil
+	case "atr":
+		return NewATR(params[0]), nil
+	case "rsi":
+		return NewRSI(params[0]), nil
+	}
+	return nil, fmt.Errorf("indicator \"%s\" is not available on derived charts", name)
+}
+
+type SMA struct {
+	period int
+	values []float64
+	index  int
+	count  int
+	sum    float64
+}
+
+func NewSMA(period int) *SMA {
+	return &SMA{period: period, values: make([]float64, period)}
+}
+
+func (s *SMA) Push(c candlestick.Candle) (float64, bool) {
+	s.sum += c.Close - s.values[s.index]
+	s.values[s.index] = c.Close
+	s.index = (s.index + 1) % s.period
+	s.count++
+	return s.sum / float64(s.period), s.count >= s.period
+}
+
+// EMA is seeded with the simple average of its first period
+type EMA struct {
+	sma   *SMA
+	alpha float64
+	value float64
+	ready bool
+}
+
+func NewEMA(period int) *EMA {
+	return &EMA{sma: NewSMA(period), alpha: 2 / float64(period+1)}
+}
+
+func (s *EMA) Push(c candlestick.Candle) (float64, bool) {
+	if s.ready {
+		s.value += s.alpha * (c.Close - s.value)
+		return s.value, true
+	}
+	s.value, s.ready = s.sma.Push(c)
+	return s.value, s.ready
+}
+
+// ATR uses Wilder smoothing of the true range
+type ATR struct {
+	period int
+	prev   *candlestick.Candle
+	count  int
+	value  float64
+}
+
+func NewATR(period int) *ATR {
+	return &ATR{period: period}
+}
+
+func (s *ATR) Push(c candlestick.Candle) (float64, bool) {
+	tr := c.High - c.Low
+	if s.prev != nil {
+		tr = math.Max(tr, math.Max(math.Abs(c.High-s.prev.Close), math.Abs(c.Low-s.prev.Close)))
+	}
+	s.prev = &c
+	s.count++
+	if s.count <= s.period {
+		s.value += tr / float64(s.period)
+	} else {
+		s.value = (s.value*float64(s.period-1) + tr) / float64(s.period)
+	}
+	return s.value, s.count >= s.period
+}
+
+// RSI uses Wilder smoothing of gains and losses
+type RSI struct {
+	period int
+	prev   *candlestick.Candle
+	count  int
+	gain   float64
+	loss   float64
+}
+
+func NewRSI(period int) *RSI {
+	return &RSI{period: period}
+}
+
+func (s *RSI) Push(c candlestick.Candle) (float64, bool) {
+	if s.prev == nil {
+		s.prev = &c
+		return 0, false
+	}
+	change := c.Close - s.prev.Close
+	s.prev = &c
+	s.count++
+	gain, loss := math.Max(change, 0), math.Max(-change, 0)
+	if s.count <= s.period {
+		s.gain += gain / float64(s.period)
+		s.loss += loss / float64(s.period)
+	} else {
+		s.gain = (s.gain*float64(s.period-1) + gain) / float64(s.period)
+		s.loss = (s.loss*float64(s.period-1) + loss) / float64(s.period)
+	}
+	if s.count < s.period {
+		return 0, false
+	}
+	if s.loss == 0 {
+		return 100, true
+	}
+	return 100 - 100/(1+s.gain/s.loss), true
+}
@@ -0,0 +1,166 @@
+package compression
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	Identity = "identity"
+	Gzip     = "gzip"
+	Zstd     = "zstd"
+)
+
+// AcceptEncoding lists the supported encodings in order of preference
+const AcceptEncoding = Zstd + ", " + Gzip
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	}}
+	zstdWriters = sync.Pool{New: func() interface{} {
+		w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1))
+		return w
+	}}
+	zstdReaders = sync.Pool{New: func() interface{} {
+		r, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		return r
+	}}
+)
+
+// Supported reports whether a content encoding can be decoded, an empty encoding means identity
+func Supported(encoding string) bool {
+	switch normalize(encoding) {
+	case "", Identity, Gzip, Zstd:
+		return true
+	}
+	return false
+}
+
+func normalize(encoding string) string {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if encoding == "x-gzip" {
+		return Gzip
+	}
+	return encoding
+}
+
+// Negotiate picks the preferred supported encoding of an Accept-Encoding header, identity when
+// nothing is supported
+func Negotiate(accept string) string {
+	allowed := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		name := normalize(fields[0])
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if name == "*" {
+			allowed[Zstd] = allowed[Zstd] || q > 0
+			allowed[Gzip] = allowed[Gzip] || q > 0
+			continue
+		}
+		allowed[name] = q > 0
+	}
+	for _, encoding := range []string{Zstd, Gzip} {
+		if allowed[encoding] {
+			return encoding
+		}
+	}
+	return Identity
+}
+
+// NewReader decodes a stream with the given content encoding, closing the reader closes the
+// underlying stream
+func NewReader(encoding string, r io.ReadCloser) (io.ReadCloser, error) {
+	switch normalize(encoding) {
+	case "", Identity:
+		return r, nil
+	case Gzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &reader{Reader: gz, close: func() { _ = gz.Close() }, source: r}, nil
+	case Zstd:
+		zr := zstdReaders.Get().(*zstd.Decoder)
+		if err := zr.Reset(r); err != nil {
+			zstdReaders.Put(zr)
+			return nil, err
+		}
+		return &reader{Reader: zr, close: func() {
+			_ = zr.Reset(nil)
+			zstdReaders.Put(zr)
+		}, source: r}, nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %s", encoding)
+}
+
+type reader struct {
+	io.Reader
+	once   sync.Once
+	close  func()
+	source io.ReadCloser
+}
+
+func (r *reader) Close() error {
+	r.once.Do(r.close)
+	return r.source.Close()
+}
+
+// NewWriter compresses a stream with the given content encoding, closing the writer flushes the
+// remaining data but leaves the underlying stream open
+func NewWriter(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch normalize(encoding) {
+	case "", Identity:
+		return nopCloser{w}, nil
+	case Gzip:
+		gz := gzipWriters.Get().(*gzip.Writer)
+		gz.Reset(w)
+		return &writer{Writer: gz, close: func() error {
+			err := gz.Close()
+			gzipWriters.Put(gz)
+			return err
+		}}, nil
+	case Zstd:
+		zw := zstdWriters.Get().(*zstd.Encoder)
+		zw.Reset(w)
+		return &writer{Writer: zw, close: func() error {
+			err := zw.Close()
+			zstdWriters.Put(zw)
+			return err
+		}}, nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %s", encoding)
+}
+
+type writer struct {
+	io.Writer
+	once  sync.Once
+	close func() error
+	err   error
+}
+
+func (w *writer) Close() error {
+	w.once.Do(func() { w.err = w.close() })
+	return w.err
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
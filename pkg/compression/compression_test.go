@@ -0,0 +1,57 @@
+package compression
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	expected := map[string]string{
+		"":                     Identity,
+		"gzip, deflate, br":    Gzip,
+		"gzip;q=0.5, zstd":     Zstd,
+		"zstd;q=0, gzip":       Gzip,
+		"*":                    Zstd,
+		"br":                   Identity,
+		"x-gzip;q=1, zstd;q=0": Gzip,
+	}
+	for accept, encoding := range expected {
+		if got := Negotiate(accept); got != encoding {
+			fmt.Printf("expected %s for %q but got %s\n", encoding, accept, got)
+			t.Fail()
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	payload := strings.Repeat("{\"time\":1650000000,\"label\":\"high\"},", 1000)
+	for _, encoding := range []string{Identity, Gzip, Zstd} {
+		buf := new(bytes.Buffer)
+		w, err := NewWriter(encoding, buf)
+		if err != nil {
+			panic(err)
+		}
+		_, _ = io.WriteString(w, payload)
+		if err = w.Close(); err != nil {
+			panic(err)
+		}
+		if encoding != Identity && buf.Len() >= len(payload) {
+			fmt.Printf("expected %s to compress the payload\n", encoding)
+			t.Fail()
+		}
+
+		r, err := NewReader(encoding, io.NopCloser(buf))
+		if err != nil {
+			panic(err)
+		}
+		decoded, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil || string(decoded) != payload {
+			fmt.Printf("round trip with %s failed: %v\n", encoding, err)
+			t.Fail()
+		}
+	}
+}
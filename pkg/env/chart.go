@@ -0,0 +1,54 @@
+package env
+
+type ChartKind = int
+
+const (
+	_ ChartKind = iota
+	ChartHeikinAshi
+	ChartRenko
+	ChartRenkoATR
+	ChartRange
+	ChartVolume
+	ChartResampled
+)
+
+// AnchorMonday aligns weekly intervals to monday, the unix epoch starts on a thursday
+const AnchorMonday int64 = 4 * 24 * 60 * 60
+
+// ChartSpec describes an alternative bar construction computed locally from the candles of an interval
+type ChartSpec struct {
+	Kind     ChartKind
+	Interval int64
+	Size     float64
+	Target   int64
+	Anchor   int64
+}
+
+func HeikinAshi(interval int64) ChartSpec {
+	return ChartSpec{Kind: ChartHeikinAshi, Interval: interval}
+}
+
+// Renko creates bricks of a fixed price size
+func Renko(interval int64, size float64) ChartSpec {
+	return ChartSpec{Kind: ChartRenko, Interval: interval, Size: size}
+}
+
+// RenkoATR creates bricks sized by the average true range over the given period
+func RenkoATR(interval int64, period int) ChartSpec {
+	return ChartSpec{Kind: ChartRenkoATR, Interval: interval, Size: float64(period)}
+}
+
+// RangeBars creates a bar each time the price range reaches the given size
+func RangeBars(interval int64, size float64) ChartSpec {
+	return ChartSpec{Kind: ChartRange, Interval: interval, Size: size}
+}
+
+// VolumeBars creates a bar each time the traded volume reaches the given amount
+func VolumeBars(interval int64, volume float64) ChartSpec {
+	return ChartSpec{Kind: ChartVolume, Interval: interval, Size: volume}
+}
+
+// Resample aggregates candles of the base interval into a custom interval aligned to the anchor
+func Resample(base int64, interval int64, anchor int64) ChartSpec {
+	return ChartSpec{Kind: ChartResampled, Interval: base, Target: interval, Anchor: anchor}
+}
@@ -8,6 +8,7 @@ import (
 type MarketSupplier interface {
 	Algorithm(name string, params ...float64) AlgorithmSupplier
 	Interval(interval int64) IntervalSupplier
+	Chart(spec ChartSpec) IntervalSupplier
 	Price() float64
 	Time() int64
 }
@@ -31,4 +32,23 @@ type AlgorithmSupplier interface {
 	PastEvents() []*algo.Event
 	LastEvents() []*algo.Event
 	HasEvents() bool
+	// LastEvent returns the most recent event or nil when there is none
+	LastEvent() *algo.Event
+	// EventsSince returns the events created after the timestamp
+	EventsSince(timestamp int64) []*algo.Event
+	// EventsInLast returns the events created during the last candles
+	EventsInLast(candles int) []*algo.Event
+	CountSince(timestamp int64) int
+	Filter(filter EventFilter) []*algo.Event
+	FilterSince(filter EventFilter, timestamp int64) []*algo.Event
+	// LastMatching returns the most recent event matching the filter or nil when there is none
+	LastMatching(filter EventFilter) *algo.Event
+	LastWithLabel(label string) *algo.Event
+}
+
+// EventFilter matches events on every non-empty field
+type EventFilter struct {
+	Label string
+	Color string
+	Icon  string
 }
@@ -1,5 +1,10 @@
 package env
 
+import (
+	"bytes"
+	"encoding/gob"
+)
+
 type Memory struct {
 	data interface{}
 }
@@ -18,6 +23,42 @@ func (m *Memory) Read() interface{} {
 	return m.data
 }
 
+// RegisterMemory makes the type of a value stored in memory serialisable, every concrete type
+// stored in memory, also within other values, must be registered to checkpoint a strategy
+func RegisterMemory(value interface{}) {
+	gob.Register(value)
+}
+
+type memoryData struct {
+	Data interface{}
+}
+
+// MarshalBinary serialises the stored value, types of stored values must be registered
+// with RegisterMemory
+func (m *Memory) MarshalBinary() ([]byte, error) {
+	if m.data == nil {
+		return []byte{}, nil
+	}
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(&memoryData{Data: m.data}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *Memory) UnmarshalBinary(data []byte) error {
+	m.data = nil
+	if len(data) == 0 {
+		return nil
+	}
+	v := new(memoryData)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+		return err
+	}
+	m.data = v.Data
+	return nil
+}
+
 type FiLoStack struct {
 	stack   []interface{}
 	index   int
@@ -69,3 +110,29 @@ func (s *FiLoStack) At(index int) interface{} {
 func (s *FiLoStack) ToSlice() []interface{} {
 	return s.stack
 }
+
+type filoStackData struct {
+	Stack   []interface{}
+	Index   int
+	Size    int
+	Counter int
+}
+
+func (s *FiLoStack) GobEncode() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := gob.NewEncoder(buf).Encode(&filoStackData{Stack: s.stack, Index: s.index, Size: s.size, Counter: s.counter})
+	return buf.Bytes(), err
+}
+
+func (s *FiLoStack) GobDecode(data []byte) error {
+	v := new(filoStackData)
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+		return err
+	}
+	s.stack, s.index, s.size, s.counter = v.Stack, v.Index, v.Size, v.Counter
+	if len(s.stack) < s.size {
+		// gob drops trailing empty slots
+		s.stack = append(s.stack, make([]interface{}, s.size-len(s.stack))...)
+	}
+	return nil
+}
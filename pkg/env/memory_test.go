@@ -37,3 +37,48 @@ func TestFiLoStack_At(t *testing.T) {
 		t.Fail()
 	}
 }
+
+type testMemory struct {
+	Count   int
+	History *FiLoStack
+}
+
+func TestMemorySerialisation(t *testing.T) {
+
+	RegisterMemory(&testMemory{})
+	stack := NewFiLoStack(3)
+	stack.Push(1.5)
+	stack.Push(2.5)
+	mem := NewMemory()
+	mem.Store(&testMemory{Count: 2, History: stack})
+
+	data, err := mem.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	restored := NewMemory()
+	if err = restored.UnmarshalBinary(data); err != nil {
+		panic(err)
+	}
+
+	v, ok := restored.Read().(*testMemory)
+	if !ok || v.Count != 2 {
+		fmt.Println("expected stored value to be restored")
+		t.FailNow()
+	}
+	if v.History.Size() != 3 || v.History.IsFull() || v.History.At(0) != nil || v.History.At(1).(float64) != 1.5 {
+		fmt.Println("expected stack to be restored")
+		t.Fail()
+	}
+	v.History.Push(3.5)
+	if !v.History.IsFull() || v.History.At(0).(float64) != 1.5 || v.History.At(2).(float64) != 3.5 {
+		fmt.Println("expected restored stack to keep its order")
+		t.Fail()
+	}
+
+	// empty memory stays empty
+	if data, err = NewMemory().MarshalBinary(); err != nil || len(data) != 0 {
+		fmt.Println("expected empty memory to serialise to nothing")
+		t.Fail()
+	}
+}
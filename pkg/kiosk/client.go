@@ -0,0 +1,164 @@
+package kiosk
+
+import (
+	"errors"
+	"fmt"
+	"github.com/godoji/algocore/pkg/compression"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// client executes idempotent requests with retries, a concurrency limit per upstream host
+// and a circuit breaker that fails fast after repeated failures of a host
+type client struct {
+	http             *http.Client
+	retries          int
+	backoff          time.Duration
+	maxBackoff       time.Duration
+	hostConcurrency  int
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	lock             sync.Mutex
+	hosts            map[string]*hostState
+}
+
+type hostState struct {
+	slots     chan struct{}
+	failures  int
+	openUntil time.Time
+}
+
+func newClient(cfg Config) *client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = cfg.HostConcurrency
+	transport.MaxConnsPerHost = cfg.HostConcurrency
+	return &client{
+		http: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+		},
+		retries:          cfg.Retries,
+		backoff:          cfg.RetryBackoff,
+		maxBackoff:       cfg.MaxRetryBackoff,
+		hostConcurrency:  cfg.HostConcurrency,
+		breakerThreshold: cfg.BreakerThreshold,
+		breakerCooldown:  cfg.BreakerCooldown,
+		hosts:            make(map[string]*hostState),
+	}
+}
+
+func (c *client) host(name string) *hostState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	h, ok := c.hosts[name]
+	if !ok {
+		h = &hostState{slots: make(chan struct{}, c.hostConcurrency)}
+		c.hosts[name] = h
+	}
+	return h
+}
+
+// report updates the circuit breaker of a host after a request
+func (c *client) report(h *hostState, ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if ok {
+		h.failures = 0
+		return
+	}
+	h.failures++
+	if c.breakerThreshold > 0 && h.failures >= c.breakerThreshold {
+		h.openUntil = time.Now().Add(c.breakerCooldown)
+	}
+}
+
+func (c *client) isOpen(h *hostState) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return time.Now().Before(h.openUntil)
+}
+
+// delay returns the exponential backoff with jitter before the given retry
+func (c *client) delay(attempt int) time.Duration {
+	d := c.backoff << attempt
+	if d <= 0 || d > c.maxBackoff {
+		d = c.maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// get sends a GET request, network errors and 5xx responses are retried, the host slot is
+// released once the body of the returned response is closed, compressed bodies are decoded
+func (c *client) get(rawUrl string, accept string) (*http.Response, error) {
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %s: %w", rawUrl, err)
+	}
+	h := c.host(u.Host)
+	if c.isOpen(h) {
+		return nil, fmt.Errorf("%s: %w", u.Host, ErrCircuitOpen)
+	}
+
+	h.slots <- struct{}{}
+	release := func() { <-h.slots }
+
+	for attempt := 0; ; attempt++ {
+
+		req, err := http.NewRequest(http.MethodGet, rawUrl, nil)
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("failed to initialize get request for %s: %w", rawUrl, err)
+		}
+		req.Header.Set("Accept", accept)
+		req.Header.Set("Accept-Encoding", compression.AcceptEncoding)
+
+		resp, err := c.http.Do(req)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			body, err := compression.NewReader(resp.Header.Get("Content-Encoding"), resp.Body)
+			if err != nil {
+				_ = resp.Body.Close()
+				c.report(h, false)
+				release()
+				return nil, fmt.Errorf("decoding response of %s failed: %w", rawUrl, err)
+			}
+			c.report(h, true)
+			resp.Body = &releasingBody{ReadCloser: body, release: release}
+			return resp, nil
+		}
+
+		// describe the failure and discard the response
+		if err != nil {
+			err = fmt.Errorf("failed to fetch %s, network error: %w", rawUrl, err)
+		} else {
+			err = fmt.Errorf("request %s failed with code %d", rawUrl, resp.StatusCode)
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		if attempt >= c.retries {
+			c.report(h, false)
+			release()
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt+1, err)
+		}
+		time.Sleep(c.delay(attempt))
+	}
+}
+
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
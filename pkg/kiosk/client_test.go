@@ -0,0 +1,101 @@
+package kiosk
+
+import (
+	"errors"
+	"fmt"
+	"github.com/godoji/algocore/pkg/compression"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testClient() *client {
+	cfg := DefaultConfig()
+	cfg.RetryBackoff = time.Millisecond
+	cfg.MaxRetryBackoff = time.Millisecond
+	cfg.BreakerThreshold = 2
+	cfg.BreakerCooldown = time.Minute
+	return newClient(cfg)
+}
+
+func TestClientRetry(t *testing.T) {
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	resp, err := testClient().get(srv.URL, "application/octet-stream")
+	if err != nil {
+		fmt.Printf("expected request to succeed after retries but got %v\n", err)
+		t.FailNow()
+	}
+	_ = resp.Body.Close()
+	if calls != 3 {
+		fmt.Printf("expected %d attempts but got %d\n", 3, calls)
+		t.Fail()
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := testClient()
+	for i := 0; i < 2; i++ {
+		if _, err := c.get(srv.URL, "application/octet-stream"); err == nil {
+			fmt.Println("expected request to fail")
+			t.Fail()
+		}
+	}
+
+	// breaker is open, no further requests reach the host
+	before := atomic.LoadInt32(&calls)
+	if _, err := c.get(srv.URL, "application/octet-stream"); !errors.Is(err, ErrCircuitOpen) {
+		fmt.Printf("expected open circuit but got %v\n", err)
+		t.Fail()
+	}
+	if after := atomic.LoadInt32(&calls); after != before {
+		fmt.Printf("expected no request while open but got %d\n", after-before)
+		t.Fail()
+	}
+}
+
+func TestClientDecompression(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := compression.Negotiate(r.Header.Get("Accept-Encoding"))
+		enc, err := compression.NewWriter(encoding, w)
+		if err != nil {
+			panic(err)
+		}
+		w.Header().Set("Content-Encoding", encoding)
+		_, _ = enc.Write([]byte("candles"))
+		_ = enc.Close()
+	}))
+	defer srv.Close()
+
+	resp, err := testClient().get(srv.URL, "application/octet-stream")
+	if err != nil {
+		panic(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil || string(body) != "candles" {
+		fmt.Printf("expected decoded body but got %q (%v)\n", body, err)
+		t.Fail()
+	}
+}
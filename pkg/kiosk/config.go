@@ -0,0 +1,211 @@
+package kiosk
+
+import (
+	"fmt"
+	"github.com/dgraph-io/ristretto"
+	"github.com/northberg/candlestick"
+	"log"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// Config controls the upstream services and caching behaviour of the data layer
+type Config struct {
+	// KioURL serves candles and exchange info, IncaURL indicators and AlgoURL algorithm results,
+	// a service is only required once data is requested from it
+	KioURL  string
+	IncaURL string
+	AlgoURL string
+	// Live mode asks upstream services to skip their caches and expires cached data after LiveTTL
+	Live    bool
+	LiveTTL time.Duration
+	// TTL expires cached data outside of live mode, zero keeps it until evicted
+	TTL time.Duration
+	// CacheSize is the memory budget of decoded data in bytes
+	CacheSize int64
+	// DiskCacheDir enables the disk cache beneath the memory cache when set
+	DiskCacheDir  string
+	DiskCacheSize int64
+	// MaxInFlight bounds the amount of concurrent upstream requests
+	MaxInFlight int
+	// Timeout of a single request attempt including reading the body
+	Timeout time.Duration
+	// Retries of idempotent requests on network errors or 5xx responses, with exponential backoff
+	Retries         int
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// HostConcurrency limits concurrent requests and pooled connections per upstream host
+	HostConcurrency int
+	// BreakerThreshold consecutive failures of a host reject its requests for BreakerCooldown
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// CandleDecoder decodes the candle payloads of KioURL, the binary candlestick format when nil
+	CandleDecoder func([]byte) (*candlestick.CandleSet, error)
+}
+
+// DefaultConfig reads the service urls from the KIO_URL, INCA_URL and ALGO_URL env variables
+func DefaultConfig() Config {
+	return Config{
+		KioURL:           os.Getenv("KIO_URL"),
+		IncaURL:          os.Getenv("INCA_URL"),
+		AlgoURL:          os.Getenv("ALGO_URL"),
+		Live:             false,
+		LiveTTL:          time.Second,
+		TTL:              0,
+		CacheSize:        1 << 29, // 512MB
+		DiskCacheDir:     "",
+		DiskCacheSize:    1 << 32, // 4GB
+		MaxInFlight:      64,
+		Timeout:          30 * time.Second,
+		Retries:          3,
+		RetryBackoff:     100 * time.Millisecond,
+		MaxRetryBackoff:  5 * time.Second,
+		HostConcurrency:  16,
+		BreakerThreshold: 5,
+		BreakerCooldown:  10 * time.Second,
+	}
+}
+
+var (
+	config     Config
+	configured = false
+	inUse      = false
+	setupLock  = sync.Mutex{}
+	cache      *ristretto.Cache
+	disk       *DiskCache
+	requests   *flightGroup
+	httpClient *client
+)
+
+// Setup configures the caches of the data layer, it must be called before any data is
+// fetched, otherwise the default configuration is used on first use. The configuration
+// cannot be changed once the data layer is in use
+func Setup(cfg Config) error {
+	setupLock.Lock()
+	defer setupLock.Unlock()
+	if inUse {
+		return fmt.Errorf("data layer is already in use and cannot be reconfigured")
+	}
+	return setup(cfg)
+}
+
+func setup(cfg Config) error {
+
+	if cfg.CacheSize <= 0 {
+		return fmt.Errorf("cache size must be positive")
+	}
+	if cfg.MaxInFlight <= 0 {
+		return fmt.Errorf("max in flight requests must be positive")
+	}
+	if cfg.HostConcurrency <= 0 {
+		return fmt.Errorf("host concurrency must be positive")
+	}
+	if cfg.Retries < 0 {
+		return fmt.Errorf("retries cannot be negative")
+	}
+
+	// about ten counters per expected item of half a megabyte
+	memCache, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters: 10 * (cfg.CacheSize>>19 + 1),
+		MaxCost:     cfg.CacheSize,
+		BufferItems: 64,
+		Metrics:     true,
+	})
+	if err != nil {
+		return err
+	}
+
+	var diskCache *DiskCache
+	if cfg.DiskCacheDir != "" {
+		if diskCache, err = NewDiskCache(cfg.DiskCacheDir, cfg.DiskCacheSize); err != nil {
+			return err
+		}
+	}
+
+	if cache != nil {
+		cache.Close()
+	}
+	config = cfg
+	cache = memCache
+	disk = diskCache
+	requests = newFlightGroup(cfg.MaxInFlight)
+	httpClient = newClient(cfg)
+	configured = true
+	return nil
+}
+
+// ensureSetup falls back to the default configuration when Setup was never called, the data
+// layer is in use from then on so the globals it sets stay fixed
+func ensureSetup() {
+	setupLock.Lock()
+	defer setupLock.Unlock()
+	inUse = true
+	if configured {
+		return
+	}
+	if err := setup(DefaultConfig()); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// endpoint validates a service url on first use
+func endpoint(url string, env string) (string, error) {
+	if url == "" {
+		return "", fmt.Errorf("%s not set, it is required to request this data", env)
+	}
+	return url, nil
+}
+
+func kioEndpoint() (string, error) {
+	ensureSetup()
+	return endpoint(config.KioURL, "KIO_URL")
+}
+
+func incaEndpoint() (string, error) {
+	ensureSetup()
+	return endpoint(config.IncaURL, "INCA_URL")
+}
+
+func algoEndpoint() (string, error) {
+	ensureSetup()
+	return endpoint(config.AlgoURL, "ALGO_URL")
+}
+
+func isLive() bool {
+	ensureSetup()
+	return config.Live
+}
+
+func (c *Config) ttl() time.Duration {
+	if c.Live {
+		return c.LiveTTL
+	}
+	return c.TTL
+}
+
+func storeCache(url string, e interface{}, cost int64) {
+	if ttl := config.ttl(); ttl > 0 {
+		cache.SetWithTTL(url, e, cost, ttl)
+	} else {
+		cache.Set(url, e, cost)
+	}
+}
+
+// decodedCost estimates the memory used by a decoded payload
+func decodedCost(e interface{}) int64 {
+	switch v := e.(type) {
+	case *candlestick.CandleSet:
+		return int64(len(v.Candles)) * int64(unsafe.Sizeof(candlestick.Candle{}))
+	case *candlestick.Indicator:
+		cost := int64(0)
+		for _, series := range v.Series {
+			if len(series.Values) > 0 {
+				cost += int64(len(series.Values)) * int64(unsafe.Sizeof(series.Values[0]))
+			}
+		}
+		return cost + 1
+	}
+	return 1
+}
@@ -0,0 +1,49 @@
+package kiosk
+
+import (
+	"fmt"
+	"github.com/godoji/algocore/internal/kiosktest/market"
+	"strings"
+	"testing"
+)
+
+func TestSetupInUse(t *testing.T) {
+
+	setupLock.Lock()
+	inUse = false
+	setupLock.Unlock()
+
+	if err := Setup(DefaultConfig()); err != nil {
+		panic(err)
+	}
+	Metrics()
+
+	// swapping the caches while data is fetched is not allowed
+	if err := Setup(DefaultConfig()); err == nil {
+		fmt.Println("expected reconfiguration of the data layer in use to be rejected")
+		t.Fail()
+	}
+}
+
+func TestMarketServiceOnly(t *testing.T) {
+
+	srv := useUpstream(market.Handler())
+	defer srv.Close()
+
+	// candles are served without the indicator and algorithm services
+	candles, err := GetCandles(0, 86400, 86400, "TEST:X:A")
+	if err != nil || len(candles.Candles) == 0 {
+		fmt.Printf("expected candles with only KIO_URL set but got %v\n", err)
+		t.Fail()
+	}
+
+	// missing services are reported on first use
+	if _, err = GetAlgorithm("trend", 86400, "TEST:X:A", []float64{}, false); err == nil || !strings.Contains(err.Error(), "ALGO_URL not set") {
+		fmt.Printf("expected missing ALGO_URL to be reported but got %v\n", err)
+		t.Fail()
+	}
+	if _, err = GetIndicator(0, "sma", 86400, 86400, "TEST:X:A", []int{5}); err == nil || !strings.Contains(err.Error(), "INCA_URL not set") {
+		fmt.Printf("expected missing INCA_URL to be reported but got %v\n", err)
+		t.Fail()
+	}
+}
@@ -4,200 +4,190 @@ import (
 	"encoding/gob"
 	"encoding/json"
 	"fmt"
-	"github.com/dgraph-io/ristretto"
 	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/bars"
 	"github.com/northberg/candlestick"
 	"io"
 	"log"
 	"math"
 	"net/http"
-	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var kioUrl, incaUrl, algoUrl string
-
 var (
-	cache       *ristretto.Cache
-	cacheLive   = false
-	cacheConfig = ristretto.Config{
-		NumCounters: 512,
-		MaxCost:     1 << 29, // 512MB
-		BufferItems: 64,
-	}
 	marketInfoCache     *candlestick.ExchangeList = nil
 	marketInfoCacheLock                           = sync.Mutex{}
 )
 
-// setup caches
-func init() {
-
-	if len(os.Args) < 2 {
-		return
-	}
-	mode := os.Args[1]
-	if mode == "live" {
-		cacheLive = true
-	}
-
-	var err error
-	if cache, err = ristretto.NewCache(&cacheConfig); err != nil {
-		log.Fatal(err)
-	}
+// CacheMetrics describes the state of the request cache for monitoring
+type CacheMetrics struct {
+	Hits         uint64 `json:"hits"`
+	Misses       uint64 `json:"misses"`
+	Evictions    uint64 `json:"evictions"`
+	CostAdded    uint64 `json:"costAdded"`
+	CostEvicted  uint64 `json:"costEvicted"`
+	BytesFetched uint64 `json:"bytesFetched"`
+	Requests     uint64 `json:"requests"`
+	Failures     uint64 `json:"failures"`
+	Shared       uint64 `json:"shared"`
+	InFlight     int    `json:"inFlight"`
+	DiskSize     int64  `json:"diskSize"`
 }
 
-func init() {
-	if v := os.Getenv("KIO_URL"); v != "" {
-		kioUrl = v
-	} else {
-		log.Fatalln("KIO_URL not set")
-	}
-	if v := os.Getenv("INCA_URL"); v != "" {
-		incaUrl = v
-	} else {
-		log.Fatalln("INCA_URL not set")
-	}
-	if v := os.Getenv("ALGO_URL"); v != "" {
-		algoUrl = v
-	} else {
-		log.Fatalln("ALGO_URL not set")
-	}
-}
-
-func getRequestProgress(url string) int {
-	inProgressLock.Lock()
-	progress := inProgress[url]
-	inProgressLock.Unlock()
-	return progress
-}
+var (
+	bytesFetched uint64
+	requestCount uint64
+	failedCount  uint64
+)
 
-func setRequestProgress(url string, state int) {
-	inProgressLock.Lock()
-	inProgress[url] = state
-	inProgressLock.Unlock()
+func Metrics() *CacheMetrics {
+	ensureSetup()
+	diskSize := int64(0)
+	if disk != nil {
+		diskSize = disk.Size()
+	}
+	return &CacheMetrics{
+		Hits:         cache.Metrics.Hits(),
+		Misses:       cache.Metrics.Misses(),
+		Evictions:    cache.Metrics.KeysEvicted(),
+		CostAdded:    cache.Metrics.CostAdded(),
+		CostEvicted:  cache.Metrics.CostEvicted(),
+		BytesFetched: atomic.LoadUint64(&bytesFetched),
+		Requests:     atomic.LoadUint64(&requestCount),
+		Failures:     atomic.LoadUint64(&failedCount),
+		Shared:       atomic.LoadUint64(&requests.shared),
+		InFlight:     requests.inFlight(),
+		DiskSize:     diskSize,
+	}
 }
 
-func fetch(url string, decoder func([]byte) (interface{}, error)) (interface{}, error) {
+// fetch retrieves and decodes a url through the memory cache, payloads with a non-empty
+// disk key are also persisted in the disk cache
+func fetch(url string, diskKey string, decoder func([]byte) (interface{}, error)) (interface{}, error) {
 
-	// lock fetch queue
-	inProgressLock.Lock()
-	_, exists := inProgress[url] // check if fetch is in progress
-	if !exists {
-		inProgress[url] = 1 // mark url as being fetched
-	}
-	inProgressLock.Unlock()
+	ensureSetup()
 
-	// do not send same request, wait
-	if exists {
-		for getRequestProgress(url) == 1 {
-			time.Sleep(5 * time.Millisecond)
-		}
+	// serve from cache when possible
+	if c, ok := cache.Get(url); ok {
+		return c, nil
 	}
 
-	if getRequestProgress(url) == 2 {
-		c, ok := cache.Get(url)
-		if ok {
+	// do not send the same request twice, wait for the one in flight instead
+	return requests.do(url, func() (interface{}, error) {
+		if c, ok := cache.Get(url); ok {
 			return c, nil
-		} else {
-			setRequestProgress(url, 1)
 		}
-	}
+		if e, ok := fromDisk(url, diskKey, decoder); ok {
+			return e, nil
+		}
+		e, err := download(url, diskKey, decoder)
+		if err != nil {
+			atomic.AddUint64(&failedCount, 1)
+		}
+		return e, err
+	})
+}
 
-	// setup request
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+func fromDisk(url string, key string, decoder func([]byte) (interface{}, error)) (interface{}, bool) {
+	if disk == nil || key == "" {
+		return nil, false
+	}
+	body, ok := disk.Get(key)
+	if !ok {
+		return nil, false
+	}
+	e, err := decoder(body)
 	if err != nil {
-		log.Println(url)
-		log.Printf("failed to initialize get request\n")
-		log.Fatalln(err)
+		log.Printf("dropping corrupt disk cache entry for %s: %s\n", url, err.Error())
+		disk.Remove(key)
+		return nil, false
 	}
+	storeCache(url, e, decodedCost(e))
+	return e, true
+}
 
-	// set headers
-	req.Header.Set("Accept", "application/octet-stream")
+func download(url string, diskKey string, decoder func([]byte) (interface{}, error)) (interface{}, error) {
 
 	// execute request and handle any connection or url based error
-	resp, err := http.DefaultClient.Do(req)
+	atomic.AddUint64(&requestCount, 1)
+	resp, err := httpClient.get(url, "application/octet-stream")
 	if err != nil {
-		log.Println(url)
-		log.Printf("failed to fetch, network error\n")
-		log.Fatalln(err)
+		return nil, err
 	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if err := resp.Body.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
 
 	// case when no candle data exists
 	if resp.StatusCode == http.StatusNotFound {
-		if cacheLive {
-			cache.SetWithTTL(url, nil, 1, time.Second)
-		} else {
-			cache.Set(url, nil, 1)
-		}
+		storeCache(url, nil, 1)
 		return nil, nil
 	}
 
 	// check if response is useful
 	if resp.StatusCode != http.StatusOK {
-		log.Println(url)
-		log.Fatalf("indicator request failed with code %d\n", resp.StatusCode)
-	}
-
-	if resp.Body == nil {
-		log.Println(url)
-		log.Fatalf("decoding data failed\n")
+		return nil, fmt.Errorf("request %s failed with code %d", url, resp.StatusCode)
 	}
 
 	// read data
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Println(url)
-		log.Fatalf("reading payload failed: %s\n", err.Error())
+		return nil, fmt.Errorf("reading payload of %s failed: %w", url, err)
 	}
+	atomic.AddUint64(&bytesFetched, uint64(len(body)))
 
 	// decode data
 	e, err := decoder(body)
 	if err != nil {
-		log.Println(url)
-		log.Fatalf("decoding data failed: %s\n", err.Error())
-	}
-
-	// drain request
-	if _, err = io.Copy(io.Discard, resp.Body); err != nil {
-		log.Fatal(err)
-	}
-
-	if err = resp.Body.Close(); err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("decoding data of %s failed: %w", url, err)
 	}
 
-	// update cache
-	if cacheLive {
-		cache.SetWithTTL(url, e, 1<<19, time.Second)
-	} else {
-		cache.Set(url, e, 1<<19)
+	// update caches
+	storeCache(url, e, decodedCost(e))
+	if disk != nil && diskKey != "" {
+		disk.Set(diskKey, body)
 	}
 
-	// update get status
-	inProgressLock.Lock()
-	inProgress[url] = 2
-	inProgressLock.Unlock()
-
 	return e, nil
 }
 
-var inProgress = make(map[string]int)
-var inProgressLock = sync.Mutex{}
+// isCompleteBlock reports whether a block lies fully in the past, the current block is still
+// growing and is never persisted
+func isCompleteBlock(block int64, resolution int64) bool {
+	return block < time.Now().UTC().Unix()/(resolution*candlestick.CandleSetSize)
+}
 
 func GetCandles(block int64, interval int64, resolution int64, symbol string) (*candlestick.CandleSet, error) {
 
+	kioUrl, err := kioEndpoint()
+	if err != nil {
+		return nil, err
+	}
+
 	// cache
 	cacheParam := ""
-	if cacheLive {
+	if isLive() {
 		cacheParam = "&cache=no-cache"
 	}
 
 	// fetch
 	url := fmt.Sprintf("%s/market/t/%s?segment=%d&interval=%d&resolution=%d%s",
 		kioUrl, symbol, block, interval, resolution, cacheParam)
-	raw, err := fetch(url, func(b []byte) (interface{}, error) { return candlestick.DecodeCandleSet(b) })
+	key := ""
+	if isCompleteBlock(block, resolution) {
+		key = fmt.Sprintf("candles/%s/%d/%d/%d", symbol, block, interval, resolution)
+	}
+	decode := candlestick.DecodeCandleSet
+	if config.CandleDecoder != nil {
+		decode = config.CandleDecoder
+	}
+	raw, err := fetch(url, key, func(b []byte) (interface{}, error) { return decode(b) })
 	if err != nil {
 		return nil, err
 	}
@@ -240,16 +230,34 @@ func GetAllCandles(interval int64, resolution int64, symbol string) ([]*candlest
 	return collection, nil
 }
 
+// GetResampledCandles aggregates all candles of the base interval into a custom interval
+func GetResampledCandles(base int64, interval int64, anchor int64, symbol string) ([]candlestick.Candle, error) {
+	sets, err := GetAllCandles(base, base, symbol)
+	if err != nil {
+		return nil, err
+	}
+	return bars.Resample(sets, base, interval, anchor)
+}
+
 func GetIndicator(block int64, name string, interval int64, resolution int64, symbol string, params []int) (*candlestick.Indicator, error) {
 
+	incaUrl, err := incaEndpoint()
+	if err != nil {
+		return nil, err
+	}
+
 	cacheParam := ""
-	if cacheLive {
+	if isLive() {
 		cacheParam = "&cache=no-cache"
 	}
 
 	url := fmt.Sprintf("%s/indicators/t/%s?block=%d&interval=%d&resolution=%d&symbol=%s&params=%s%s",
 		incaUrl, name, block, interval, resolution, symbol, concatParams(params), cacheParam)
-	raw, err := fetch(url, func(b []byte) (interface{}, error) { return candlestick.DecodeIndicatorSet(b) })
+	key := ""
+	if isCompleteBlock(block, resolution) {
+		key = fmt.Sprintf("indicators/%s/%s/%s/%d/%d/%d", symbol, name, concatParams(params), block, interval, resolution)
+	}
+	raw, err := fetch(url, key, func(b []byte) (interface{}, error) { return candlestick.DecodeIndicatorSet(b) })
 	if err != nil {
 		return nil, err
 	}
@@ -265,9 +273,14 @@ func GetIndicator(block int64, name string, interval int64, resolution int64, sy
 
 func GetAlgorithm(name string, resolution int64, symbol string, params []float64, useCache bool) (*algo.ScenarioSet, error) {
 
+	algoUrl, err := algoEndpoint()
+	if err != nil {
+		return nil, err
+	}
+
 	// cache
 	cacheParam := ""
-	if cacheLive || !useCache {
+	if isLive() || !useCache {
 		cacheParam = "&force=true"
 	}
 
@@ -275,24 +288,18 @@ func GetAlgorithm(name string, resolution int64, symbol string, params []float64
 	url := fmt.Sprintf("%s/algorithms/%s/symbols/%s?resolution=%d&params=%s%s",
 		algoUrl, name, symbol, resolution, concatParamsFloat(params), cacheParam)
 
-	// setup request
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		log.Println(url)
-		log.Printf("failed to initialize get request\n")
-		log.Fatalln(err)
-	}
-
-	// set headers
-	req.Header.Set("Accept", "application/octet-stream")
-
-	// execute request and handle any connection or url based error
-	resp, err := http.DefaultClient.Do(req)
+	// execute request and handle any connection or url based error, the binary format is
+	// preferred over gob which older algorithm services still send
+	resp, err := httpClient.get(url, algo.BinaryMediaType+", application/octet-stream;q=0.9")
 	if err != nil {
-		log.Println(url)
-		log.Printf("failed to fetch, network error\n")
-		log.Fatalln(err)
+		return nil, err
 	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if err := resp.Body.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
 
 	// case when no candle data exists
 	if resp.StatusCode == http.StatusNotFound {
@@ -301,30 +308,20 @@ func GetAlgorithm(name string, resolution int64, symbol string, params []float64
 
 	// check if response is useful
 	if resp.StatusCode != http.StatusOK {
-		log.Println(url)
-		log.Fatalf("indicator request failed with code %d\n", resp.StatusCode)
-	}
-
-	if resp.Body == nil {
-		log.Println(url)
-		log.Fatalf("decoding data failed\n")
+		return nil, fmt.Errorf("algorithm request %s failed with code %d", url, resp.StatusCode)
 	}
 
 	// read data
-	result := new(algo.ScenarioSet)
-	err = gob.NewDecoder(resp.Body).Decode(result)
-	if err != nil {
-		log.Println(url)
-		log.Fatalf("reading payload failed: %s\n", err.Error())
-	}
-
-	// drain request
-	if _, err = io.Copy(io.Discard, resp.Body); err != nil {
-		log.Fatal(err)
+	if resp.Header.Get("Content-Type") == algo.BinaryMediaType {
+		result, err := algo.DecodeScenarioSet(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("reading payload of %s failed: %w", url, err)
+		}
+		return result, nil
 	}
-
-	if err = resp.Body.Close(); err != nil {
-		log.Fatal(err)
+	result := new(algo.ScenarioSet)
+	if err = gob.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("reading payload of %s failed: %w", url, err)
 	}
 
 	return result, nil
@@ -337,24 +334,31 @@ func GetExchangeInfo() (*candlestick.ExchangeList, error) {
 
 	if marketInfoCache == nil {
 
+		kioUrl, err := kioEndpoint()
+		if err != nil {
+			return nil, err
+		}
+
 		// fetch
-		resp, err := http.Get(fmt.Sprintf("%s/market/info", kioUrl))
+		resp, err := httpClient.get(fmt.Sprintf("%s/market/info", kioUrl), "application/json")
 		if err != nil {
 			return nil, err
 		}
 
 		// decode
 		result := new(candlestick.ExchangeList)
-		err = json.NewDecoder(resp.Body).Decode(result)
+		if resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("exchange info request failed with code %d", resp.StatusCode)
+		} else {
+			err = json.NewDecoder(resp.Body).Decode(result)
+		}
 
 		// drain and close body
-		if resp.Body != nil {
-			if _, err := io.Copy(io.Discard, resp.Body); err != nil {
-				log.Print(err)
-			}
-			if err := resp.Body.Close(); err != nil {
-				log.Print(err)
-			}
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			log.Print(err)
+		}
+		if err := resp.Body.Close(); err != nil {
+			log.Print(err)
 		}
 
 		if err != nil {
@@ -0,0 +1,190 @@
+package kiosk
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// diskCacheVersion is bumped whenever the layout or payload format changes, old entries are ignored
+const diskCacheVersion = 1
+
+// DiskCache persists raw block payloads, files are addressed by the hash of the block identity
+// and evicted least recently used first once the size limit is exceeded
+type DiskCache struct {
+	dir     string
+	maxSize int64
+	lock    sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type diskEntry struct {
+	name string
+	size int64
+}
+
+func NewDiskCache(dir string, maxSize int64) (*DiskCache, error) {
+	d := &DiskCache{
+		dir:     filepath.Join(dir, fmt.Sprintf("v%d", diskCacheVersion)),
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	// restore usage order from modification times of previous runs
+	type found struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	files := make([]found, 0)
+	err := filepath.WalkDir(d.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(path) == ".tmp" {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, found{name: entry.Name(), size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+	for _, f := range files {
+		d.entries[f.name] = d.order.PushFront(&diskEntry{name: f.name, size: f.size})
+		d.size += f.size
+	}
+	d.lock.Lock()
+	d.evict()
+	d.lock.Unlock()
+
+	return d, nil
+}
+
+func (d *DiskCache) path(name string) string {
+	return filepath.Join(d.dir, name[:2], name)
+}
+
+func diskKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+func (d *DiskCache) Get(key string) ([]byte, bool) {
+	name := diskKey(key)
+	d.lock.Lock()
+	e, ok := d.entries[name]
+	if ok {
+		d.order.MoveToFront(e)
+	}
+	d.lock.Unlock()
+	if !ok {
+		return nil, false
+	}
+	data, err := os.ReadFile(d.path(name))
+	if err != nil {
+		d.Remove(key)
+		return nil, false
+	}
+	now := time.Now()
+	_ = os.Chtimes(d.path(name), now, now)
+	return data, true
+}
+
+func (d *DiskCache) Set(key string, data []byte) {
+	name := diskKey(key)
+	path := d.path(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Println(err)
+		return
+	}
+
+	// write to a temporary file of this writer first so readers never see partial payloads,
+	// concurrent writers of the same key each rename a complete payload into place
+	if err := writeTemp(path, data); err != nil {
+		log.Println(err)
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if e, ok := d.entries[name]; ok {
+		d.size -= e.Value.(*diskEntry).size
+		d.order.Remove(e)
+	}
+	d.entries[name] = d.order.PushFront(&diskEntry{name: name, size: int64(len(data))})
+	d.size += int64(len(data))
+	d.evict()
+}
+
+func (d *DiskCache) Remove(key string) {
+	name := diskKey(key)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if e, ok := d.entries[name]; ok {
+		d.remove(e)
+	}
+}
+
+func (d *DiskCache) Size() int64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.size
+}
+
+// evict removes the least recently used files until the cache fits, lock must be held
+func (d *DiskCache) evict() {
+	for d.size > d.maxSize && d.order.Len() > 0 {
+		d.remove(d.order.Back())
+	}
+}
+
+func (d *DiskCache) remove(e *list.Element) {
+	entry := e.Value.(*diskEntry)
+	d.order.Remove(e)
+	delete(d.entries, entry.name)
+	d.size -= entry.size
+	if err := os.Remove(d.path(entry.name)); err != nil && !os.IsNotExist(err) {
+		log.Println(err)
+	}
+}
+
+// writeTemp writes data to a uniquely named temporary file next to path and renames it into place
+func writeTemp(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+package kiosk
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDiskCache(t *testing.T) {
+
+	dir := t.TempDir()
+	d, err := NewDiskCache(dir, 10)
+	if err != nil {
+		panic(err)
+	}
+
+	d.Set("a", []byte("1234"))
+	d.Set("b", []byte("5678"))
+	if _, ok := d.Get("a"); !ok {
+		fmt.Println("expected a to be cached")
+		t.Fail()
+	}
+
+	// b is least recently used and is evicted
+	d.Set("c", []byte("90"))
+	d.Set("d", []byte("12"))
+	if _, ok := d.Get("b"); ok {
+		fmt.Println("expected b to be evicted")
+		t.Fail()
+	}
+	if size := d.Size(); size != 8 {
+		fmt.Printf("expected size %d but got %d\n", 8, size)
+		t.Fail()
+	}
+
+	// entries survive a restart
+	d, err = NewDiskCache(dir, 10)
+	if err != nil {
+		panic(err)
+	}
+	if data, ok := d.Get("a"); !ok || string(data) != "1234" {
+		fmt.Printf("expected a to be restored but got %s\n", data)
+		t.Fail()
+	}
+}
+
+func TestDiskCacheConcurrentWriters(t *testing.T) {
+
+	// two caches sharing a directory stand in for two processes
+	dir := t.TempDir()
+	first, err := NewDiskCache(dir, 1<<30)
+	if err != nil {
+		panic(err)
+	}
+	second, err := NewDiskCache(dir, 1<<30)
+	if err != nil {
+		panic(err)
+	}
+
+	payloads := [][]byte{bytes.Repeat([]byte("a"), 1<<20), bytes.Repeat([]byte("b"), 1<<19)}
+	complete := func(data []byte) bool {
+		return bytes.Equal(data, payloads[0]) || bytes.Equal(data, payloads[1])
+	}
+	first.Set("block", payloads[0])
+
+	// readers only ever see complete payloads while writers replace them
+	var partial int32
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if data, ok := first.Get("block"); ok && !complete(data) {
+				atomic.AddInt32(&partial, 1)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i, d := range []*DiskCache{first, first, second, second} {
+		wg.Add(1)
+		go func(d *DiskCache, payload []byte) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				d.Set("block", payload)
+			}
+		}(d, payloads[i%2])
+	}
+	wg.Wait()
+	close(done)
+	readers.Wait()
+	if partial != 0 {
+		fmt.Printf("expected only complete payloads but read %d partial ones\n", partial)
+		t.Fail()
+	}
+
+	// the stored payload is one of the written ones and no temporary files are left
+	data, ok := first.Get("block")
+	if !ok || !complete(data) {
+		fmt.Printf("expected a complete payload but got %d bytes\n", len(data))
+		t.Fail()
+	}
+	temps, _ := filepath.Glob(filepath.Join(first.dir, "*", "*.tmp"))
+	if len(temps) != 0 {
+		fmt.Printf("expected no temporary files but got %v\n", temps)
+		t.Fail()
+	}
+}
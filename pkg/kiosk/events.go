@@ -0,0 +1,170 @@
+package kiosk
+
+import (
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"sort"
+	"sync"
+)
+
+// eventIndex answers queries over the events of an algorithm, events are ordered by creation
+// time so visible events are found with a binary search, positions of events matching a filter
+// are indexed on first use
+type eventIndex struct {
+	events  []*algo.Event
+	lock    sync.Mutex
+	matches map[env.EventFilter][]int
+}
+
+func newEventIndex(events []*algo.Event) *eventIndex {
+	return &eventIndex{
+		events:  events,
+		matches: make(map[env.EventFilter][]int),
+	}
+}
+
+// index returns the shared event index of an algorithm result
+func (s *AlgorithmStore) index(set *algo.ScenarioSet) *eventIndex {
+	s.indexLock.Lock()
+	defer s.indexLock.Unlock()
+	x, ok := s.indexes[set]
+	if !ok {
+		x = newEventIndex(set.Events)
+		s.indexes[set] = x
+	}
+	return x
+}
+
+// until returns the amount of events created at or before the timestamp
+func (x *eventIndex) until(timestamp int64) int {
+	return sort.Search(len(x.events), func(i int) bool {
+		return x.events[i].CreatedOn > timestamp
+	})
+}
+
+func matches(e *algo.Event, f env.EventFilter) bool {
+	return (f.Label == "" || e.Label == f.Label) &&
+		(f.Color == "" || e.Color == f.Color) &&
+		(f.Icon == "" || e.Icon == f.Icon)
+}
+
+// positions returns the ordered positions of the events matching a filter
+func (x *eventIndex) positions(f env.EventFilter) []int {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	p, ok := x.matches[f]
+	if !ok {
+		p = make([]int, 0)
+		for i, e := range x.events {
+			if matches(e, f) {
+				p = append(p, i)
+			}
+		}
+		x.matches[f] = p
+	}
+	return p
+}
+
+// filter returns the matching events among the first end events
+func (x *eventIndex) filter(f env.EventFilter, begin int, end int) []*algo.Event {
+	p := x.positions(f)
+	from := sort.SearchInts(p, begin)
+	to := sort.SearchInts(p, end)
+	result := make([]*algo.Event, to-from)
+	for i := range result {
+		result[i] = x.events[p[from+i]]
+	}
+	return result
+}
+
+// last returns the last matching event among the first end events
+func (x *eventIndex) last(f env.EventFilter, end int) *algo.Event {
+	p := x.positions(f)
+	i := sort.SearchInts(p, end)
+	if i == 0 {
+		return nil
+	}
+	return x.events[p[i-1]]
+}
+
+type AlgorithmSupplier struct {
+	name   string
+	parent *DataSupplier
+	index  *eventIndex
+}
+
+// startEndIndex returns the range of events created during the current step, events before
+// the range were created during earlier steps
+func (s AlgorithmSupplier) startEndIndex() (int, int) {
+	stepEnd := s.parent.Time()
+	stepBegin := stepEnd - s.parent.algorithms.resolution
+	return s.index.until(stepBegin), s.index.until(stepEnd)
+}
+
+func (s AlgorithmSupplier) HasEvents() bool {
+	begin, end := s.startEndIndex()
+	return begin < end
+}
+
+func (s AlgorithmSupplier) Events() []*algo.Event {
+	_, end := s.startEndIndex()
+	return s.index.events[:end]
+}
+
+func (s AlgorithmSupplier) PastEvents() []*algo.Event {
+	begin, _ := s.startEndIndex()
+	return s.index.events[:begin]
+}
+
+func (s AlgorithmSupplier) LastEvents() []*algo.Event {
+	begin, end := s.startEndIndex()
+	return s.index.events[begin:end]
+}
+
+func (s AlgorithmSupplier) LastEvent() *algo.Event {
+	_, end := s.startEndIndex()
+	if end == 0 {
+		return nil
+	}
+	return s.index.events[end-1]
+}
+
+func (s AlgorithmSupplier) EventsSince(timestamp int64) []*algo.Event {
+	_, end := s.startEndIndex()
+	begin := s.index.until(timestamp)
+	if begin > end {
+		begin = end
+	}
+	return s.index.events[begin:end]
+}
+
+func (s AlgorithmSupplier) EventsInLast(candles int) []*algo.Event {
+	return s.EventsSince(s.parent.Time() - int64(candles)*s.parent.algorithms.resolution)
+}
+
+func (s AlgorithmSupplier) CountSince(timestamp int64) int {
+	return len(s.EventsSince(timestamp))
+}
+
+func (s AlgorithmSupplier) Filter(filter env.EventFilter) []*algo.Event {
+	_, end := s.startEndIndex()
+	return s.index.filter(filter, 0, end)
+}
+
+func (s AlgorithmSupplier) FilterSince(filter env.EventFilter, timestamp int64) []*algo.Event {
+	_, end := s.startEndIndex()
+	begin := s.index.until(timestamp)
+	if begin > end {
+		begin = end
+	}
+	return s.index.filter(filter, begin, end)
+}
+
+func (s AlgorithmSupplier) LastMatching(filter env.EventFilter) *algo.Event {
+	_, end := s.startEndIndex()
+	return s.index.last(filter, end)
+}
+
+func (s AlgorithmSupplier) LastWithLabel(label string) *algo.Event {
+	return s.LastMatching(env.EventFilter{Label: label})
+}
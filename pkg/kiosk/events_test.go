@@ -0,0 +1,50 @@
+package kiosk
+
+import (
+	"fmt"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"testing"
+)
+
+func TestEventIndex(t *testing.T) {
+
+	events := []*algo.Event{
+		{CreatedOn: 60, Label: "high", Color: "green"},
+		{CreatedOn: 120, Label: "low", Color: "red"},
+		{CreatedOn: 120, Label: "high", Color: "red"},
+		{CreatedOn: 300, Label: "low", Color: "green"},
+		{CreatedOn: 420, Label: "high", Color: "green"},
+	}
+	x := newEventIndex(events)
+
+	// visible events are found by creation time
+	expectedUntil := map[int64]int{0: 0, 60: 1, 119: 1, 120: 3, 400: 4, 500: 5}
+	for ts, n := range expectedUntil {
+		if got := x.until(ts); got != n {
+			fmt.Printf("expected %d events until %d but got %d\n", n, ts, got)
+			t.Fail()
+		}
+	}
+
+	// filters only consider visible events
+	end := x.until(300)
+	highs := x.filter(env.EventFilter{Label: "high"}, 0, end)
+	if len(highs) != 2 || highs[1] != events[2] {
+		fmt.Printf("expected 2 visible highs but got %d\n", len(highs))
+		t.Fail()
+	}
+	greens := x.filter(env.EventFilter{Color: "green"}, x.until(60), end)
+	if len(greens) != 1 || greens[0] != events[3] {
+		fmt.Printf("expected 1 green event since 60 but got %d\n", len(greens))
+		t.Fail()
+	}
+	if last := x.last(env.EventFilter{Label: "high", Color: "red"}, end); last != events[2] {
+		fmt.Println("expected last red high to be the third event")
+		t.Fail()
+	}
+	if last := x.last(env.EventFilter{Label: "high"}, 0); last != nil {
+		fmt.Println("expected no event before the first one")
+		t.Fail()
+	}
+}
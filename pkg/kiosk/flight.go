@@ -0,0 +1,67 @@
+package kiosk
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// call is a fetch in progress, waiters block on done and share its result
+type call struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+}
+
+// flightGroup makes sure only one request per key is executed at a time
+type flightGroup struct {
+	lock   sync.Mutex
+	calls  map[string]*call
+	slots  chan struct{}
+	shared uint64
+}
+
+func newFlightGroup(maxInFlight int) *flightGroup {
+	return &flightGroup{
+		calls: make(map[string]*call),
+		slots: make(chan struct{}, maxInFlight),
+	}
+}
+
+// do executes fn for a key, concurrent callers for the same key wait for and receive the same
+// result or error, at most maxInFlight functions run concurrently
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+
+	g.lock.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.lock.Unlock()
+		atomic.AddUint64(&g.shared, 1)
+		<-c.done
+		return c.val, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	g.calls[key] = c
+	g.lock.Unlock()
+
+	// waiters receive this error when fn panics, the panic itself is passed to the caller
+	c.err = fmt.Errorf("fetch of %s panicked", key)
+	g.slots <- struct{}{}
+	defer func() {
+		<-g.slots
+
+		// forget the call so the next request is served from cache or fetched again on error
+		g.lock.Lock()
+		delete(g.calls, key)
+		g.lock.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn()
+
+	return c.val, c.err
+}
+
+func (g *flightGroup) inFlight() int {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return len(g.calls)
+}
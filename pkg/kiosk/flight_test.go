@@ -0,0 +1,88 @@
+package kiosk
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroup(t *testing.T) {
+
+	g := newFlightGroup(2)
+	var executed int32
+	failure := errors.New("upstream failed")
+
+	// all concurrent callers share a single execution and its error
+	var wait sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			_, err := g.do("key", func() (interface{}, error) {
+				atomic.AddInt32(&executed, 1)
+				time.Sleep(20 * time.Millisecond)
+				return nil, failure
+			})
+			if err != failure {
+				fmt.Printf("expected error to be shared but got %v\n", err)
+				t.Fail()
+			}
+		}()
+	}
+	wait.Wait()
+
+	if executed != 1 {
+		fmt.Printf("expected %d execution but got %d\n", 1, executed)
+		t.Fail()
+	}
+	if n := g.inFlight(); n != 0 {
+		fmt.Printf("expected no calls in flight but got %d\n", n)
+		t.Fail()
+	}
+
+	// a failed call is retried by the next caller
+	v, err := g.do("key", func() (interface{}, error) { return 5, nil })
+	if err != nil || v.(int) != 5 {
+		fmt.Printf("expected %d but got %v (%v)\n", 5, v, err)
+		t.Fail()
+	}
+}
+
+func TestFlightGroupPanic(t *testing.T) {
+
+	g := newFlightGroup(1)
+	started := make(chan struct{})
+	waiter := make(chan error)
+	go func() {
+		<-started
+		_, err := g.do("key", func() (interface{}, error) { return nil, nil })
+		waiter <- err
+	}()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				fmt.Println("expected panic to reach the caller")
+				t.Fail()
+			}
+		}()
+		_, _ = g.do("key", func() (interface{}, error) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			panic("decode failed")
+		})
+	}()
+
+	// waiters are released and the slot is free for the next call
+	if err := <-waiter; err == nil {
+		fmt.Println("expected waiter to receive an error")
+		t.Fail()
+	}
+	if _, err := g.do("other", func() (interface{}, error) { return nil, nil }); err != nil || g.inFlight() != 0 {
+		fmt.Println("expected slot to be released after a panic")
+		t.Fail()
+	}
+}
@@ -1,6 +1,7 @@
 package kiosk
 
 import (
+	"fmt"
 	"github.com/godoji/algocore/pkg/algo"
 	"github.com/godoji/algocore/pkg/env"
 	"github.com/northberg/candlestick"
@@ -38,6 +39,7 @@ type DataSupplier struct {
 type DataStore struct {
 	provider      *Provider
 	block         int64
+	candleLock    sync.Mutex
 	candles       map[int64]*candlestick.CandleSet
 	indicatorLock sync.Mutex
 	indicators    map[string]*IndicatorSubStore
@@ -58,20 +60,62 @@ type AlgorithmSubStore struct {
 	Lock sync.Mutex
 }
 
+// fetchIndicator retrieves an indicator of the block, failures are recorded on the provider and return nil
 func (s *DataStore) fetchIndicator(name string, interval int64, params []int) *candlestick.Indicator {
-	var err error
+	s.provider.usage.addIndicator(name, interval, params)
 	indicator, err := GetIndicator(s.block, name, interval, s.provider.resolution, s.provider.symbol.ToString(), params)
 	if err != nil {
-		log.Fatalln(err)
+		s.provider.fail(err)
+		return nil
 	}
 	if indicator == nil {
-		log.Fatalf("indicator \"%s\" does not exist\n", name)
+		s.provider.fail(fmt.Errorf("indicator \"%s\" does not exist", name))
 	}
 	return indicator
 }
 
 func (s *DataStore) Indicator(name string, interval int64, params []int) *candlestick.Indicator {
 
+	// look in bucket for indicator
+	arr := s.bucket(name, params)
+	arr.Lock.Lock()
+	if v := arr.find(name, interval, params); v != nil {
+		arr.Lock.Unlock()
+		return v
+	}
+
+	// fetch and add to bucket if not found
+	indicator := s.fetchIndicator(name, interval, params)
+	if indicator != nil {
+		arr.Data = append(arr.Data, indicator)
+	}
+	arr.Lock.Unlock()
+
+	return indicator
+}
+
+// preloadIndicator fetches an indicator into the store unless it is already present
+func (s *DataStore) preloadIndicator(name string, interval int64, params []int) error {
+	arr := s.bucket(name, params)
+	arr.Lock.Lock()
+	defer arr.Lock.Unlock()
+	if arr.find(name, interval, params) != nil {
+		return nil
+	}
+	indicator, err := GetIndicator(s.block, name, interval, s.provider.resolution, s.provider.symbol.ToString(), params)
+	if err != nil {
+		return err
+	}
+	if indicator == nil {
+		return fmt.Errorf("indicator \"%s\" does not exist", name)
+	}
+	arr.Data = append(arr.Data, indicator)
+	return nil
+}
+
+// bucket finds the bucket of an indicator based on its name and first parameter
+func (s *DataStore) bucket(name string, params []int) *ParamSubStore {
+
 	// retrieve map of indicators
 	s.indicatorLock.Lock()
 	subStore, ok := s.indicators[name]
@@ -82,22 +126,23 @@ func (s *DataStore) Indicator(name string, interval int64, params []int) *candle
 	s.indicatorLock.Unlock()
 
 	// find bucket based on params
-	var arr *ParamSubStore
 	key := 0
 	if len(params) > 0 {
 		key = params[0]
 	}
 
 	subStore.Lock.Lock()
-	arr, ok = subStore.Data[key]
+	defer subStore.Lock.Unlock()
+	arr, ok := subStore.Data[key]
 	if !ok {
 		arr = &ParamSubStore{Data: make([]*candlestick.Indicator, 0)}
 		subStore.Data[key] = arr
 	}
-	subStore.Lock.Unlock()
+	return arr
+}
 
-	// look in bucket for indicator
-	arr.Lock.Lock()
+// find looks for an indicator in the bucket, the lock of the bucket must be held
+func (arr *ParamSubStore) find(name string, interval int64, params []int) *candlestick.Indicator {
 	for _, v := range arr.Data {
 		if v.Meta.Name != name {
 			panic("wrong indicator in sub-store")
@@ -116,29 +161,49 @@ func (s *DataStore) Indicator(name string, interval int64, params []int) *candle
 			}
 		}
 		if !invalid {
-			arr.Lock.Unlock()
 			return v
 		}
 	}
-
-	// fetch and add to bucket if not found
-	indicator := s.fetchIndicator(name, interval, params)
-	arr.Data = append(arr.Data, indicator)
-	arr.Lock.Unlock()
-
-	return indicator
+	return nil
 }
 
-func (s *AlgorithmStore) fetchAlgorithm(name string, params []float64) *algo.ScenarioSet {
-	var err error
+func (s *AlgorithmStore) fetchAlgorithm(name string, params []float64) (*algo.ScenarioSet, error) {
+	if s.resolver != nil {
+		result, ok, err := s.resolver(name, params)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return result, nil
+		}
+	}
 	result, err := GetAlgorithm(name, s.resolution, s.symbol.ToString(), params, true)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
 	if result == nil {
-		log.Fatalf("algorithm \"%s\" does not exist\n", name)
+		return nil, fmt.Errorf("algorithm \"%s\" does not exist", name)
+	}
+	if len(result.Parameters) != len(params) {
+		return nil, fmt.Errorf("parameter mismatch of algorithm \"%s\": expected %d parameters but got %d instead, parameters must be passed explicitly", name, len(params), len(result.Parameters))
+	}
+	return result, nil
+}
+
+// Err returns the first error fetching an algorithm, algorithms which failed have no events
+// so the simulation can stop at its next check
+func (s *AlgorithmStore) Err() error {
+	s.errLock.Lock()
+	defer s.errLock.Unlock()
+	return s.err
+}
+
+func (s *AlgorithmStore) fail(err error) {
+	s.errLock.Lock()
+	defer s.errLock.Unlock()
+	if s.err == nil {
+		s.err = err
 	}
-	return result
 }
 
 func (s *AlgorithmStore) algorithm(name string, params []float64) *algo.ScenarioSet {
@@ -171,44 +236,83 @@ func (s *AlgorithmStore) algorithm(name string, params []float64) *algo.Scenario
 		}
 	}
 
-	// fetch and add to bucket if not found
-	result := s.fetchAlgorithm(name, params)
+	// fetch and add to bucket if not found, failed algorithms are kept without events
+	result, err := s.fetchAlgorithm(name, params)
+	if err != nil {
+		s.fail(err)
+		result = &algo.ScenarioSet{Events: make([]*algo.Event, 0), Parameters: params}
+	}
 	arr.Data = append(arr.Data, result)
 	arr.Lock.Unlock()
 
-	if len(result.Parameters) != len(params) {
-		log.Println("parameter mismatch: parameters must be passed explicitly")
-		log.Fatalf("expected %d parameters but got %d instead", len(params), len(result.Parameters))
-	}
-
 	return result
 }
 
 func (s *DataStore) CandleSet(interval int64) *candlestick.CandleSet {
+	s.candleLock.Lock()
 	candles, ok := s.candles[interval]
+	s.candleLock.Unlock()
 	if !ok {
+		s.provider.usage.addInterval(interval)
 		var err error
 		candles, err = GetCandles(s.block, interval, s.provider.resolution, s.provider.symbol.ToString())
-		if err != nil {
-			log.Fatalln(err)
+		if err == nil && candles == nil {
+			err = fmt.Errorf("failed to fetch %s candles for block %d (%d)", s.provider.symbol.ToString(), s.block, interval)
 		}
-		if candles == nil {
-			log.Fatalf("failed to fetch %s candles for block %d (%d)\n", s.provider.symbol.ToString(), s.block, interval)
+		if err != nil {
+			// the block is simulated as closed market, the run fails once the block is done
+			s.provider.fail(err)
+			candles = &candlestick.CandleSet{Candles: make([]candlestick.Candle, candlestick.CandleSetSize)}
+			for i := range candles.Candles {
+				candles.Candles[i].Missing = true
+			}
 		}
+		s.candleLock.Lock()
 		s.candles[interval] = candles
+		s.candleLock.Unlock()
 	}
 	return candles
 }
 
+// preloadCandles fetches the candles of an interval into the store unless they are present
+func (s *DataStore) preloadCandles(interval int64) error {
+	s.candleLock.Lock()
+	_, ok := s.candles[interval]
+	s.candleLock.Unlock()
+	if ok {
+		return nil
+	}
+	candles, err := GetCandles(s.block, interval, s.provider.resolution, s.provider.symbol.ToString())
+	if err != nil {
+		return err
+	}
+	if candles == nil {
+		return fmt.Errorf("no %s candles for block %d (%d)", s.provider.symbol.ToString(), s.block, interval)
+	}
+	s.candleLock.Lock()
+	if _, ok = s.candles[interval]; !ok {
+		s.candles[interval] = candles
+	}
+	s.candleLock.Unlock()
+	return nil
+}
+
 type Provider struct {
 	symbol     candlestick.AssetIdentifier
 	resolution int64
+	seriesLock sync.Mutex
+	derived    map[env.ChartSpec]*DerivedSeries
+	usage      *usage
+	errLock    sync.Mutex
+	err        error
 }
 
 func NewProvider(symbol candlestick.AssetIdentifier, resolution int64) *Provider {
 	return &Provider{
 		symbol:     symbol,
 		resolution: resolution,
+		derived:    make(map[env.ChartSpec]*DerivedSeries),
+		usage:      newUsage(),
 	}
 }
 
@@ -225,11 +329,27 @@ func (p *Provider) Resolution() int64 {
 	return p.resolution
 }
 
+// Err returns the first error fetching data of the asset, data which failed is missing
+func (p *Provider) Err() error {
+	p.errLock.Lock()
+	defer p.errLock.Unlock()
+	return p.err
+}
+
+func (p *Provider) fail(err error) {
+	p.errLock.Lock()
+	defer p.errLock.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// Info retrieves the asset info, failures are recorded on the provider and return nil
 func (p *Provider) Info() *candlestick.AssetInfo {
 	exchangeInfo, err := GetExchangeInfo()
 	if err != nil {
-		log.Println("failed to retrieve exchange info")
-		log.Fatalln(err)
+		p.fail(fmt.Errorf("failed to retrieve exchange info: %w", err))
+		return nil
 	}
 	for _, exchange := range exchangeInfo.Exchanges {
 		if exchange.BrokerId != p.symbol.Broker {
@@ -241,7 +361,7 @@ func (p *Provider) Info() *candlestick.AssetInfo {
 		}
 		return info
 	}
-	log.Fatalf("could not find broker for asset: %s\n", p.symbol.ToString())
+	p.fail(fmt.Errorf("could not find broker for asset: %s", p.symbol.ToString()))
 	return nil
 }
 
@@ -298,75 +418,59 @@ type AlgorithmStore struct {
 	algorithms    map[string]*AlgorithmSubStore
 	resolution    int64
 	symbol        candlestick.AssetIdentifier
+	resolver      AlgorithmResolver
+	errLock       sync.Mutex
+	err           error
+	indexLock     sync.Mutex
+	indexes       map[*algo.ScenarioSet]*eventIndex
+}
+
+// AlgorithmResolver evaluates algorithms available in process, ok is false for algorithms
+// which have to be requested from the algorithm service
+type AlgorithmResolver = func(name string, params []float64) (result *algo.ScenarioSet, ok bool, err error)
+
+// SetResolver resolves algorithms in process before falling back to the algorithm service
+func (s *AlgorithmStore) SetResolver(resolver AlgorithmResolver) *AlgorithmStore {
+	s.resolver = resolver
+	return s
 }
 
 func NewAlgorithmStore(symbol candlestick.AssetIdentifier, resolution int64) *AlgorithmStore {
 	return &AlgorithmStore{
 		algorithms: map[string]*AlgorithmSubStore{},
+		indexes:    map[*algo.ScenarioSet]*eventIndex{},
 		resolution: resolution,
 		symbol:     symbol,
 	}
 }
 
-func (s *DataSupplier) Algorithm(name string, params ...float64) env.AlgorithmSupplier {
-	return AlgorithmSupplier{
-		name:     name,
-		parent:   s,
-		scenario: s.algorithms.algorithm(name, params),
-	}
-}
-
-type AlgorithmSupplier struct {
-	name     string
-	parent   *DataSupplier
-	scenario *algo.ScenarioSet
+// AlgorithmRequest describes an algorithm requested from an algorithm store
+type AlgorithmRequest struct {
+	Name   string
+	Params []float64
 }
 
-func (s AlgorithmSupplier) HasEvents() bool {
-	stepEnd := s.parent.Time()
-	stepStart := stepEnd - s.parent.algorithms.resolution
-	for _, event := range s.scenario.Events {
-		if event.CreatedOn > stepStart && event.CreatedOn <= stepEnd {
-			return true
-		}
-		if event.CreatedOn > stepEnd {
-			return false
+// Requested lists the algorithms requested from the store so far
+func (s *AlgorithmStore) Requested() []AlgorithmRequest {
+	s.algorithmLock.Lock()
+	defer s.algorithmLock.Unlock()
+	result := make([]AlgorithmRequest, 0)
+	for name, arr := range s.algorithms {
+		arr.Lock.Lock()
+		for _, v := range arr.Data {
+			result = append(result, AlgorithmRequest{Name: name, Params: v.Parameters})
 		}
+		arr.Lock.Unlock()
 	}
-	return false
+	return result
 }
 
-func (s AlgorithmSupplier) startEndIndex() (int, int) {
-	stepEnd := s.parent.Time()
-	stepBegin := stepEnd - s.parent.algorithms.resolution
-
-	indexEnd := 0
-	indexBegin := -1
-	for i, event := range s.scenario.Events {
-		if event.CreatedOn > stepEnd {
-			break
-		}
-		if indexBegin == -1 && stepBegin < event.CreatedOn {
-			indexBegin = i
-		}
-		indexEnd = i + 1
+func (s *DataSupplier) Algorithm(name string, params ...float64) env.AlgorithmSupplier {
+	return AlgorithmSupplier{
+		name:   name,
+		parent: s,
+		index:  s.algorithms.index(s.algorithms.algorithm(name, params)),
 	}
-	return indexBegin, indexEnd
-}
-
-func (s AlgorithmSupplier) Events() []*algo.Event {
-	_, end := s.startEndIndex()
-	return s.scenario.Events[:end]
-}
-
-func (s AlgorithmSupplier) PastEvents() []*algo.Event {
-	begin, _ := s.startEndIndex()
-	return s.scenario.Events[:begin]
-}
-
-func (s AlgorithmSupplier) LastEvents() []*algo.Event {
-	begin, end := s.startEndIndex()
-	return s.scenario.Events[begin:end]
 }
 
 type IndicatorSupplier struct {
@@ -376,6 +480,9 @@ type IndicatorSupplier struct {
 }
 
 func (s IndicatorSupplier) Exists() bool {
+	if s.indicator == nil {
+		return false
+	}
 	for _, series := range s.indicator.Series {
 		if series.Values[s.parent.index].Missing {
 			return false
@@ -389,6 +496,9 @@ func (s IndicatorSupplier) Value() float64 {
 }
 
 func (s IndicatorSupplier) Series(key string) float64 {
+	if s.indicator == nil {
+		return 0
+	}
 	v, ok := s.indicator.Series[key]
 	if !ok {
 		log.Fatalf("indicator series \"%s\" does not exist in \"%s\"\n", key, s.name)
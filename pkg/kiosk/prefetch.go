@@ -0,0 +1,215 @@
+package kiosk
+
+import (
+	"log"
+	"sync"
+)
+
+type indicatorUsage struct {
+	name     string
+	interval int64
+	params   []int
+}
+
+// usage remembers which intervals and indicators a strategy requested so upcoming blocks
+// can be fetched before the strategy needs them
+type usage struct {
+	lock       sync.Mutex
+	intervals  map[int64]bool
+	indicators map[string]*indicatorUsage
+}
+
+func newUsage() *usage {
+	return &usage{
+		intervals:  make(map[int64]bool),
+		indicators: make(map[string]*indicatorUsage),
+	}
+}
+
+func (u *usage) addInterval(interval int64) {
+	u.lock.Lock()
+	u.intervals[interval] = true
+	u.lock.Unlock()
+}
+
+func (u *usage) addIndicator(name string, interval int64, params []int) {
+	key := name + ":" + concatParams([]int{int(interval)}) + ":" + concatParams(params)
+	u.lock.Lock()
+	if _, ok := u.indicators[key]; !ok {
+		u.indicators[key] = &indicatorUsage{name: name, interval: interval, params: params}
+	}
+	u.lock.Unlock()
+}
+
+func (u *usage) snapshot() ([]int64, []*indicatorUsage) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	intervals := make([]int64, 0, len(u.intervals))
+	for interval := range u.intervals {
+		intervals = append(intervals, interval)
+	}
+	indicators := make([]*indicatorUsage, 0, len(u.indicators))
+	for _, indicator := range u.indicators {
+		indicators = append(indicators, indicator)
+	}
+	return intervals, indicators
+}
+
+// IndicatorRequest describes an indicator requested from a provider
+type IndicatorRequest struct {
+	Name     string
+	Interval int64
+	Params   []int
+}
+
+// ExpectIndicator marks an indicator as used before the strategy requests it so it is prefetched
+// from the first block on
+func (p *Provider) ExpectIndicator(name string, interval int64, params []int) {
+	p.usage.addIndicator(name, interval, params)
+}
+
+// Indicators lists the indicators requested from the provider so far
+func (p *Provider) Indicators() []IndicatorRequest {
+	_, indicators := p.usage.snapshot()
+	result := make([]IndicatorRequest, len(indicators))
+	for i, indicator := range indicators {
+		result[i] = IndicatorRequest{Name: indicator.name, Interval: indicator.interval, Params: indicator.params}
+	}
+	return result
+}
+
+// warmConcurrency bounds the amount of concurrent fetches of a prefetcher
+const warmConcurrency = 4
+
+// Prefetcher hands out the data stores of consecutive blocks and loads the data used so far
+// into the stores of the next blocks in the background while the current block is evaluated.
+// Prefetched data is held by the stores so it survives eviction from the caches
+type Prefetcher struct {
+	provider *Provider
+	depth    int
+	last     int64
+	lock     sync.Mutex
+	stores   map[int64]*DataStore
+	warming  map[int64]int
+	slots    chan struct{}
+	done     chan struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// NewPrefetcher prefetches up to depth blocks ahead but never beyond the last block, it must
+// be closed to stop prefetching
+func NewPrefetcher(provider *Provider, depth int, last int64) *Prefetcher {
+	return &Prefetcher{
+		provider: provider,
+		depth:    depth,
+		last:     last,
+		stores:   make(map[int64]*DataStore),
+		warming:  make(map[int64]int),
+		slots:    make(chan struct{}, warmConcurrency),
+		done:     make(chan struct{}),
+	}
+}
+
+// Store returns the data store of a block, stores of older blocks are released
+func (p *Prefetcher) Store(block int64) *DataStore {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	store, ok := p.stores[block]
+	if !ok {
+		store = p.provider.NewDataStore(block)
+		p.stores[block] = store
+	}
+	for b := range p.stores {
+		if b < block-1 {
+			delete(p.stores, b)
+		}
+	}
+	for b := range p.warming {
+		if b <= block {
+			delete(p.warming, b)
+		}
+	}
+	if p.closed {
+		return store
+	}
+
+	// warm everything used so far for the upcoming blocks, blocks are warmed again once the
+	// strategy used more data than they were warmed with
+	intervals, indicators := p.provider.usage.snapshot()
+	used := len(intervals) + len(indicators)
+	for next := block + 1; next <= block+int64(p.depth) && next <= p.last; next++ {
+		if p.warming[next] >= used {
+			continue
+		}
+		p.warming[next] = used
+		if _, ok = p.stores[next]; !ok {
+			p.stores[next] = p.provider.NewDataStore(next)
+		}
+		p.wg.Add(1)
+		go p.warm(p.stores[next], intervals, indicators)
+	}
+
+	return store
+}
+
+// Close stops prefetching and waits for fetches in progress
+func (p *Prefetcher) Close() {
+	p.lock.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+	}
+	p.lock.Unlock()
+	p.wg.Wait()
+}
+
+// acquire waits for a free slot, false once the prefetcher is closed
+func (p *Prefetcher) acquire() bool {
+	select {
+	case p.slots <- struct{}{}:
+	case <-p.done:
+		return false
+	}
+	select {
+	case <-p.done:
+		<-p.slots
+		return false
+	default:
+		return true
+	}
+}
+
+func (p *Prefetcher) warm(store *DataStore, intervals []int64, indicators []*indicatorUsage) {
+	defer p.wg.Done()
+
+	var wg sync.WaitGroup
+	for _, interval := range intervals {
+		if !p.acquire() {
+			break
+		}
+		wg.Add(1)
+		go func(interval int64) {
+			defer wg.Done()
+			defer func() { <-p.slots }()
+			if err := store.preloadCandles(interval); err != nil {
+				log.Printf("prefetching candles of block %d failed: %s\n", store.block, err.Error())
+			}
+		}(interval)
+	}
+	for _, indicator := range indicators {
+		if !p.acquire() {
+			break
+		}
+		wg.Add(1)
+		go func(indicator *indicatorUsage) {
+			defer wg.Done()
+			defer func() { <-p.slots }()
+			if err := store.preloadIndicator(indicator.name, indicator.interval, indicator.params); err != nil {
+				log.Printf("prefetching indicator of block %d failed: %s\n", store.block, err.Error())
+			}
+		}(indicator)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,146 @@
+package kiosk
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/northberg/candlestick"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useUpstream configures the data layer to fetch candles encoded as json from a test server,
+// the indicator and algorithm services are not configured
+func useUpstream(handler http.Handler) *httptest.Server {
+	srv := httptest.NewServer(handler)
+	setupLock.Lock()
+	inUse = false
+	setupLock.Unlock()
+	cfg := DefaultConfig()
+	cfg.KioURL = srv.URL
+	cfg.IncaURL = ""
+	cfg.AlgoURL = ""
+	cfg.CandleDecoder = func(b []byte) (*candlestick.CandleSet, error) {
+		candles := new(candlestick.CandleSet)
+		return candles, json.Unmarshal(b, candles)
+	}
+	if err := Setup(cfg); err != nil {
+		panic(err)
+	}
+	return srv
+}
+
+// candleHandler counts requests per segment, requests wait for release when it is not nil
+func candleHandler(requests *int32, segments *sync.Map, release chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		if segments != nil {
+			n, _ := segments.LoadOrStore(r.URL.Query().Get("segment"), new(int32))
+			atomic.AddInt32(n.(*int32), 1)
+		}
+		if release != nil {
+			<-release
+		}
+		_ = json.NewEncoder(w).Encode(&candlestick.CandleSet{Candles: make([]candlestick.Candle, candlestick.CandleSetSize)})
+	})
+}
+
+func waitFor(condition func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if condition() {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+func TestPrefetcher(t *testing.T) {
+
+	var requests int32
+	var segments sync.Map
+	srv := useUpstream(candleHandler(&requests, &segments, nil))
+	defer srv.Close()
+
+	provider := NewProvider(candlestick.NewAssetIdentifier("A", "B", "C"), 60)
+	provider.usage.addInterval(60)
+	p := NewPrefetcher(provider, 2, 10)
+	defer p.Close()
+
+	p.Store(0)
+	if !waitFor(func() bool { return atomic.LoadInt32(&requests) == 2 }) {
+		fmt.Printf("expected %d blocks to be prefetched but got %d\n", 2, requests)
+		t.FailNow()
+	}
+
+	// prefetched blocks are held by their stores even when the cache dropped them
+	time.Sleep(10 * time.Millisecond)
+	cache.Clear()
+	p.Store(1).CandleSet(60)
+	p.Store(2).CandleSet(60)
+	for _, segment := range []string{"1", "2"} {
+		if n, _ := segments.Load(segment); atomic.LoadInt32(n.(*int32)) != 1 {
+			fmt.Printf("expected block %s to be fetched once but got %d requests\n", segment, *n.(*int32))
+			t.Fail()
+		}
+	}
+}
+
+func TestPrefetcherClose(t *testing.T) {
+
+	var requests int32
+	release := make(chan struct{})
+	srv := useUpstream(candleHandler(&requests, nil, release))
+	defer srv.Close()
+
+	provider := NewProvider(candlestick.NewAssetIdentifier("A", "B", "C"), 60)
+	provider.usage.addInterval(60)
+	p := NewPrefetcher(provider, 8, 10)
+	p.Store(0)
+
+	// fetches are bounded and no new ones start once closed
+	if !waitFor(func() bool { return atomic.LoadInt32(&requests) == warmConcurrency }) {
+		fmt.Printf("expected %d fetches in progress but got %d\n", warmConcurrency, requests)
+		t.FailNow()
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+	p.Close()
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&requests); n != warmConcurrency {
+		fmt.Printf("expected %d requests after closing but got %d\n", warmConcurrency, n)
+		t.Fail()
+	}
+}
+
+func TestPrefetcherUsage(t *testing.T) {
+
+	var requests int32
+	var segments sync.Map
+	srv := useUpstream(candleHandler(&requests, &segments, nil))
+	defer srv.Close()
+
+	// nothing is used before the first block is evaluated
+	provider := NewProvider(candlestick.NewAssetIdentifier("A", "B", "C"), 60)
+	p := NewPrefetcher(provider, 2, 10)
+	defer p.Close()
+	store := p.Store(0)
+	time.Sleep(10 * time.Millisecond)
+	store.CandleSet(60)
+
+	// blocks handed out before the strategy used anything are warmed once it did
+	p.Store(1)
+	if !waitFor(func() bool {
+		_, warmed := segments.Load("2")
+		_, next := segments.Load("3")
+		return warmed && next
+	}) {
+		fmt.Println("expected blocks 2 and 3 to be prefetched")
+		t.Fail()
+	}
+}
@@ -0,0 +1,317 @@
+package kiosk
+
+import (
+	"fmt"
+	"github.com/godoji/algocore/pkg/bars"
+	"github.com/godoji/algocore/pkg/env"
+	"github.com/northberg/candlestick"
+	"log"
+	"sort"
+	"sync"
+)
+
+// derivedLookback is the amount of bars a derived series keeps, older bars are dropped
+// once twice as many were built
+const derivedLookback = 4096
+
+// DerivedSeries holds bars computed locally from the candles of a base interval, bars are
+// indexed from the first bar built and only the last derivedLookback bars are kept
+type DerivedSeries struct {
+	lock       sync.Mutex
+	spec       env.ChartSpec
+	builder    bars.Builder
+	bars       []candlestick.Candle
+	available  []int64
+	offset     int
+	pending    *candlestick.Candle
+	block      int64
+	index      int
+	indicators map[string]*derivedIndicator
+}
+
+type derivedIndicator struct {
+	indicator bars.Indicator
+	values    []float64
+	valid     []bool
+	offset    int
+}
+
+func newBuilder(spec env.ChartSpec) (bars.Builder, error) {
+	switch spec.Kind {
+	case env.ChartHeikinAshi:
+		return bars.NewHeikinAshi(), nil
+	case env.ChartRenko:
+		if spec.Size > 0 {
+			return bars.NewRenko(spec.Size), nil
+		}
+	case env.ChartRenkoATR:
+		if spec.Size >= 1 {
+			return bars.NewRenkoATR(int(spec.Size)), nil
+		}
+	case env.ChartRange:
+		if spec.Size > 0 {
+			return bars.NewRange(spec.Size), nil
+		}
+	case env.ChartVolume:
+		if spec.Size > 0 {
+			return bars.NewVolume(spec.Size), nil
+		}
+	case env.ChartResampled:
+		return bars.NewResampler(spec.Interval, spec.Target, spec.Anchor)
+	default:
+		return nil, fmt.Errorf("unknown chart kind %d", spec.Kind)
+	}
+	return nil, fmt.Errorf("invalid size %f for chart kind %d", spec.Size, spec.Kind)
+}
+
+func (p *Provider) series(spec env.ChartSpec) *DerivedSeries {
+	p.seriesLock.Lock()
+	defer p.seriesLock.Unlock()
+	if d, ok := p.derived[spec]; ok {
+		return d
+	}
+	// invalid charts stay empty so every bar of them is missing
+	builder, err := newBuilder(spec)
+	if err != nil {
+		log.Printf("chart %+v: %v\n", spec, err)
+	}
+	d := &DerivedSeries{
+		spec:       spec,
+		builder:    builder,
+		bars:       make([]candlestick.Candle, 0),
+		available:  make([]int64, 0),
+		block:      -1,
+		indicators: make(map[string]*derivedIndicator),
+	}
+	p.derived[spec] = d
+	return d
+}
+
+// Charted tells whether derived charts were requested, their bars are built from the candles
+// seen by the provider so they are not part of the memory of a strategy
+func (p *Provider) Charted() bool {
+	p.seriesLock.Lock()
+	defer p.seriesLock.Unlock()
+	return len(p.derived) > 0
+}
+
+// advance feeds all base candles up to the current step of the supplier into the builder
+func (d *DerivedSeries) advance(s *DataSupplier) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.builder == nil {
+		return
+	}
+
+	// another step already moved the series past this point
+	if d.block > s.curr.block || (d.block == s.curr.block && d.index >= s.index) {
+		return
+	}
+
+	// continue in the previous block if that is where the series stopped
+	if d.block == s.prev.block {
+		d.walk(s.prev, d.index+1, int(candlestick.CandleSetSize)-1)
+		d.index = -1
+	} else if d.block != s.curr.block {
+		d.index = -1
+	}
+	d.walk(s.curr, d.index+1, s.index)
+	d.block = s.curr.block
+	d.index = s.index
+}
+
+func (d *DerivedSeries) walk(store *DataStore, from int, to int) {
+	resolution := store.provider.resolution
+	steps := store.CandleSet(resolution)
+	base := store.CandleSet(d.spec.Interval)
+	for i := from; i <= to; i++ {
+		step := steps.Candles[i]
+		if step.Missing {
+			continue
+		}
+		d.observe(base.Candles[i], step.Time, resolution)
+	}
+}
+
+// observe handles the base candle seen at a step, only completed candles are fed to the builder
+func (d *DerivedSeries) observe(c candlestick.Candle, ts int64, resolution int64) {
+	if d.pending != nil && d.pending.Time != c.Time {
+		d.push(*d.pending, ts)
+		d.pending = nil
+	}
+	if c.Missing {
+		return
+	}
+	if c.Time+d.spec.Interval <= ts+resolution {
+		d.push(c, ts)
+		d.pending = nil
+	} else {
+		d.pending = &c
+	}
+}
+
+func (d *DerivedSeries) push(c candlestick.Candle, ts int64) {
+	for _, bar := range d.builder.Push(c) {
+		d.bars = append(d.bars, bar)
+		d.available = append(d.available, ts)
+	}
+	if len(d.bars) > 2*derivedLookback {
+		d.trim(len(d.bars) - derivedLookback)
+	}
+}
+
+// trim drops the oldest bars and the indicator values computed from them
+func (d *DerivedSeries) trim(n int) {
+	d.bars = append(make([]candlestick.Candle, 0, 2*derivedLookback), d.bars[n:]...)
+	d.available = append(make([]int64, 0, 2*derivedLookback), d.available[n:]...)
+	d.offset += n
+	for _, ind := range d.indicators {
+		drop := d.offset - ind.offset
+		if drop > len(ind.values) {
+			drop = len(ind.values)
+		}
+		ind.values = append(make([]float64, 0, len(ind.values)-drop), ind.values[drop:]...)
+		ind.valid = append(make([]bool, 0, len(ind.valid)-drop), ind.valid[drop:]...)
+		ind.offset += drop
+	}
+}
+
+// visible returns the amount of bars that were completed at the given time
+func (d *DerivedSeries) visible(ts int64) int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.offset + sort.Search(len(d.available), func(i int) bool {
+		return d.available[i] > ts
+	})
+}
+
+// bar returns the bar at the given index, bars dropped from the series are missing
+func (d *DerivedSeries) bar(index int) *candlestick.Candle {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if index < d.offset {
+		return &candlestick.Candle{Missing: true}
+	}
+	c := d.bars[index-d.offset]
+	return &c
+}
+
+// indicator returns the value of a local indicator at the given bar
+func (d *DerivedSeries) indicator(name string, params []int, index int) (float64, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	key := name + ":" + concatParams(params)
+	ind, ok := d.indicators[key]
+	if !ok {
+		indicator, err := bars.NewIndicator(name, params...)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		ind = &derivedIndicator{
+			indicator: indicator,
+			values:    make([]float64, 0),
+			valid:     make([]bool, 0),
+			offset:    d.offset,
+		}
+		d.indicators[key] = ind
+	}
+	// bars were dropped before the indicator saw them
+	if ind.offset+len(ind.values) < d.offset {
+		ind.values = ind.values[:0]
+		ind.valid = ind.valid[:0]
+		ind.offset = d.offset
+	}
+	for i := ind.offset + len(ind.values); i <= index; i++ {
+		v, ok := ind.indicator.Push(d.bars[i-d.offset])
+		ind.values = append(ind.values, v)
+		ind.valid = append(ind.valid, ok)
+	}
+	if index < ind.offset {
+		return 0, false
+	}
+	return ind.values[index-ind.offset], ind.valid[index-ind.offset]
+}
+
+func (s *DataSupplier) Chart(spec env.ChartSpec) env.IntervalSupplier {
+	series := s.curr.provider.series(spec)
+	series.advance(s)
+	return DerivedSupplier{
+		series: series,
+		size:   series.visible(s.Time()),
+	}
+}
+
+type DerivedSupplier struct {
+	series *DerivedSeries
+	size   int
+}
+
+func (s DerivedSupplier) Candle() *candlestick.Candle {
+	return s.FromLast(0)
+}
+
+func (s DerivedSupplier) FromLast(offset int) *candlestick.Candle {
+	if offset < 0 {
+		log.Fatalln("time offset cannot be negative")
+	}
+	index := s.size - 1 - offset
+	if index < 0 {
+		return &candlestick.Candle{Missing: true}
+	}
+	return s.series.bar(index)
+}
+
+func (s DerivedSupplier) ToTimeStamp(index int64) int64 {
+	if index > 0 {
+		log.Fatalln("cannot look into the future")
+	}
+	i := int64(s.size-1) + index
+	if i < 0 {
+		log.Fatalln("not enough bars in derived chart")
+	}
+	c := s.series.bar(int(i))
+	if c.Missing {
+		log.Fatalln("bar is beyond the lookback of the derived chart")
+	}
+	return c.Time
+}
+
+func (s DerivedSupplier) ToIndex(timeStamp int64) int64 {
+	s.series.lock.Lock()
+	offset := s.series.offset
+	i := sort.Search(s.size-offset, func(i int) bool {
+		return s.series.bars[i].Time > timeStamp
+	})
+	s.series.lock.Unlock()
+	return int64(offset + i - s.size)
+}
+
+func (s DerivedSupplier) Indicator(name string, params ...int) env.IndicatorSupplier {
+	value, exists := s.series.indicator(name, params, s.size-1)
+	return DerivedIndicatorSupplier{
+		name:   name,
+		value:  value,
+		exists: exists,
+	}
+}
+
+type DerivedIndicatorSupplier struct {
+	name   string
+	value  float64
+	exists bool
+}
+
+func (s DerivedIndicatorSupplier) Exists() bool {
+	return s.exists
+}
+
+func (s DerivedIndicatorSupplier) Value() float64 {
+	return s.Series(s.name)
+}
+
+func (s DerivedIndicatorSupplier) Series(key string) float64 {
+	if key != s.name {
+		log.Fatalf("indicator series \"%s\" does not exist in \"%s\"\n", key, s.name)
+	}
+	return s.value
+}
@@ -0,0 +1,50 @@
+package kiosk
+
+import (
+	"fmt"
+	"github.com/godoji/algocore/pkg/env"
+	"github.com/northberg/candlestick"
+	"testing"
+)
+
+func TestDerivedSeriesLookback(t *testing.T) {
+
+	p := NewProvider(candlestick.NewAssetIdentifier("A", "B", "C"), 60)
+	d := p.series(env.HeikinAshi(60))
+
+	n := 3 * derivedLookback
+	for i := 0; i < n; i++ {
+		price := float64(i)
+		c := candlestick.Candle{Open: price, High: price, Low: price, Close: price, Time: int64(i) * 60}
+		d.observe(c, c.Time, 60)
+		d.indicator("sma", []int{3}, i)
+	}
+
+	// old bars are dropped while indexes keep counting from the first bar
+	if len(d.bars) > 2*derivedLookback {
+		fmt.Printf("expected at most %d bars but got %d\n", 2*derivedLookback, len(d.bars))
+		t.Fail()
+	}
+	if size := d.visible(int64(n) * 60); size != n {
+		fmt.Printf("expected %d visible bars but got %d\n", n, size)
+		t.FailNow()
+	}
+	if !d.bar(0).Missing || d.bar(n-1).Time != int64(n-1)*60 {
+		fmt.Println("expected only recent bars to be kept")
+		t.Fail()
+	}
+	if v, ok := d.indicator("sma", []int{3}, n-1); !ok || v != float64(n-2) {
+		fmt.Printf("expected sma of %d but got %f\n", n-2, v)
+		t.Fail()
+	}
+}
+
+func TestInvalidChart(t *testing.T) {
+
+	p := NewProvider(candlestick.NewAssetIdentifier("A", "B", "C"), 60)
+	s := DerivedSupplier{series: p.series(env.ChartSpec{Kind: env.ChartRenko, Interval: 60})}
+	if !s.Candle().Missing {
+		fmt.Println("expected bars of an invalid chart to be missing")
+		t.Fail()
+	}
+}
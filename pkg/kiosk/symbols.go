@@ -0,0 +1,203 @@
+package kiosk
+
+import (
+	"fmt"
+	"github.com/northberg/candlestick"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SymbolError describes why a requested symbol could not be resolved
+type SymbolError struct {
+	Symbol string `json:"symbol"`
+	Reason string `json:"reason"`
+}
+
+func (e *SymbolError) Error() string {
+	return fmt.Sprintf("symbol \"%s\": %s", e.Symbol, e.Reason)
+}
+
+type SymbolErrors []*SymbolError
+
+func (e SymbolErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+var (
+	aliases        = make(map[string]string)
+	watchlists     = make(map[string][]string)
+	symbolListLock = sync.Mutex{}
+)
+
+// RegisterAlias makes a short name resolve to a full symbol such as UNICORN:US:KO
+func RegisterAlias(alias string, symbol string) {
+	symbolListLock.Lock()
+	aliases[alias] = symbol
+	symbolListLock.Unlock()
+}
+
+// RegisterWatchlist makes @name resolve to the given symbols, aliases or patterns
+func RegisterWatchlist(name string, symbols []string) {
+	symbolListLock.Lock()
+	watchlists[name] = symbols
+	symbolListLock.Unlock()
+}
+
+// ParseSymbol parses an identifier of the form BROKER:EXCHANGE:TICKER
+func ParseSymbol(symbol string) (candlestick.AssetIdentifier, error) {
+	xs := strings.Split(symbol, ":")
+	if len(xs) != 3 {
+		return candlestick.AssetIdentifier{}, &SymbolError{Symbol: symbol, Reason: "expected format BROKER:EXCHANGE:TICKER"}
+	}
+	for _, x := range xs {
+		if x == "" {
+			return candlestick.AssetIdentifier{}, &SymbolError{Symbol: symbol, Reason: "empty identifier part"}
+		}
+		if strings.ContainsAny(x, "*?[") {
+			return candlestick.AssetIdentifier{}, &SymbolError{Symbol: symbol, Reason: "unexpected wildcard"}
+		}
+	}
+	return candlestick.NewAssetIdentifier(xs[0], xs[1], xs[2]), nil
+}
+
+// matchSymbol matches a symbol against a pattern with shell style wildcards per identifier part
+func matchSymbol(pattern string, symbol string) bool {
+	ps := strings.Split(pattern, ":")
+	xs := strings.Split(symbol, ":")
+	if len(ps) != len(xs) {
+		return false
+	}
+	for i := range ps {
+		if ok, err := path.Match(ps[i], xs[i]); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ListSymbols returns information on every symbol known to the market service
+func ListSymbols() (map[string]*candlestick.AssetInfo, error) {
+	info, err := GetExchangeInfo()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]*candlestick.AssetInfo)
+	for _, exchange := range info.Exchanges {
+		for symbol, symInfo := range exchange.Symbols {
+			result[symbol] = symInfo
+		}
+	}
+	return result, nil
+}
+
+// expandSymbol resolves aliases and watchlists into symbols or patterns
+func expandSymbol(symbol string) ([]string, *SymbolError) {
+	symbolListLock.Lock()
+	defer symbolListLock.Unlock()
+	if strings.HasPrefix(symbol, "@") {
+		list, ok := watchlists[symbol[1:]]
+		if !ok {
+			return nil, &SymbolError{Symbol: symbol, Reason: "unknown watchlist"}
+		}
+		return list, nil
+	}
+	if alias, ok := aliases[symbol]; ok {
+		return []string{alias}, nil
+	}
+	if !strings.Contains(symbol, ":") {
+		return nil, &SymbolError{Symbol: symbol, Reason: "unknown alias"}
+	}
+	return []string{symbol}, nil
+}
+
+// ResolveSymbols expands aliases, watchlists and wildcards such as UNICORN:US:* and checks
+// every resulting symbol against the exchange info, all unknown symbols are reported at once
+func ResolveSymbols(symbols []string) ([]candlestick.AssetIdentifier, error) {
+
+	known, err := ListSymbols()
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make(SymbolErrors, 0)
+	result := make([]candlestick.AssetIdentifier, 0)
+	added := make(map[string]bool)
+	add := func(symbol string) {
+		if added[symbol] {
+			return
+		}
+		id, err := ParseSymbol(symbol)
+		if err != nil {
+			errs = append(errs, err.(*SymbolError))
+			return
+		}
+		added[symbol] = true
+		result = append(result, id)
+	}
+
+	var resolve func(symbol string, seen map[string]bool)
+	resolve = func(symbol string, seen map[string]bool) {
+		if seen[symbol] {
+			errs = append(errs, &SymbolError{Symbol: symbol, Reason: "recursive watchlist"})
+			return
+		}
+		expanded, err := expandSymbol(symbol)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if strings.HasPrefix(symbol, "@") || len(expanded) != 1 || expanded[0] != symbol {
+			seen[symbol] = true
+			for _, s := range expanded {
+				resolve(s, seen)
+			}
+			delete(seen, symbol)
+			return
+		}
+
+		// concrete symbol
+		if !strings.ContainsAny(symbol, "*?[") {
+			if _, ok := known[symbol]; !ok {
+				if _, err := ParseSymbol(symbol); err != nil {
+					errs = append(errs, err.(*SymbolError))
+				} else {
+					errs = append(errs, &SymbolError{Symbol: symbol, Reason: "unknown symbol"})
+				}
+				return
+			}
+			add(symbol)
+			return
+		}
+
+		// wildcard, sorted for a stable order
+		matches := make([]string, 0)
+		for s := range known {
+			if matchSymbol(symbol, s) {
+				matches = append(matches, s)
+			}
+		}
+		if len(matches) == 0 {
+			errs = append(errs, &SymbolError{Symbol: symbol, Reason: "no symbols match"})
+			return
+		}
+		sort.Strings(matches)
+		for _, s := range matches {
+			add(s)
+		}
+	}
+
+	for _, symbol := range symbols {
+		resolve(symbol, make(map[string]bool))
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return result, nil
+}
@@ -0,0 +1,98 @@
+package kiosk
+
+import (
+	"errors"
+	"fmt"
+	"github.com/godoji/algocore/internal/kiosktest/market"
+	"github.com/northberg/candlestick"
+	"reflect"
+	"testing"
+)
+
+func TestParseSymbol(t *testing.T) {
+
+	if id, err := ParseSymbol("UNICORN:US:KO"); err != nil || id.ToString() != "UNICORN:US:KO" {
+		fmt.Printf("expected symbol to parse but got %v\n", err)
+		t.Fail()
+	}
+
+	for _, symbol := range []string{"", "KO", "UNICORN:KO", "UNICORN::KO", "UNICORN:US:KO:X", "UNICORN:US:*"} {
+		if _, err := ParseSymbol(symbol); err == nil {
+			fmt.Printf("expected \"%s\" to be rejected\n", symbol)
+			t.Fail()
+		}
+	}
+}
+
+func TestMatchSymbol(t *testing.T) {
+
+	if !matchSymbol("UNICORN:US:*", "UNICORN:US:KO") {
+		fmt.Println("expected wildcard to match ticker")
+		t.Fail()
+	}
+	if matchSymbol("UNICORN:US:*", "UNICORN:EU:KO") {
+		fmt.Println("expected wildcard not to match other exchange")
+		t.Fail()
+	}
+	if matchSymbol("UNICORN:*", "UNICORN:US:KO") {
+		fmt.Println("expected wildcard to match per identifier part")
+		t.Fail()
+	}
+}
+
+func TestResolveSymbols(t *testing.T) {
+
+	srv := useUpstream(market.Handler())
+	defer srv.Close()
+	RegisterAlias("resolve-a", "TEST:X:A")
+	RegisterWatchlist("resolve-list", []string{"resolve-a", "TEST:Y:*", "DEMO:X:F"})
+	RegisterWatchlist("resolve-loop", []string{"@resolve-loop"})
+
+	symbols := func(ids []candlestick.AssetIdentifier) []string {
+		result := make([]string, len(ids))
+		for i, id := range ids {
+			result[i] = id.ToString()
+		}
+		return result
+	}
+
+	// aliases, watchlists and wildcards expand in order without duplicates
+	ids, err := ResolveSymbols([]string{"TEST:X:*", "@resolve-list", "resolve-a"})
+	if err != nil {
+		panic(err)
+	}
+	expected := []string{"TEST:X:A", "TEST:X:B", "TEST:X:C", "TEST:X:D", "TEST:Y:E", "DEMO:X:F"}
+	if !reflect.DeepEqual(symbols(ids), expected) {
+		fmt.Printf("expected %v but got %v\n", expected, symbols(ids))
+		t.Fail()
+	}
+
+	// every unknown symbol is reported at once
+	_, err = ResolveSymbols([]string{"TEST:X:Z", "TEST:Z:*", "unknown", "@unknown", "@resolve-loop", "TEST:X", "TEST:X:A"})
+	var errs SymbolErrors
+	if !errors.As(err, &errs) {
+		fmt.Printf("expected symbol errors but got %v\n", err)
+		t.FailNow()
+	}
+	reasons := make(map[string]string)
+	for _, e := range errs {
+		reasons[e.Symbol] = e.Reason
+	}
+	for symbol, reason := range map[string]string{
+		"TEST:X:Z":      "unknown symbol",
+		"TEST:Z:*":      "no symbols match",
+		"unknown":       "unknown alias",
+		"@unknown":      "unknown watchlist",
+		"@resolve-loop": "recursive watchlist",
+		"TEST:X":        "expected format BROKER:EXCHANGE:TICKER",
+	} {
+		if reasons[symbol] != reason {
+			fmt.Printf("expected %s to be rejected as %s but got \"%s\"\n", symbol, reason, reasons[symbol])
+			t.Fail()
+		}
+	}
+	if len(errs) != 6 {
+		fmt.Printf("expected 6 errors but got %v\n", errs)
+		t.Fail()
+	}
+}
@@ -0,0 +1,216 @@
+package ritmic
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/godoji/algocore/internal/simulation"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"github.com/godoji/algocore/pkg/kiosk"
+	"log"
+	"sort"
+)
+
+// Checkpoint is the state of every scenario after an evaluation, continuing it only simulates
+// the candles after the last time of a scenario. Checkpoints are signed by the server as they
+// hold serialised memory. Checkpoints of evaluations which used derived charts are Charted,
+// their bars are not part of the checkpoint so they cannot be continued
+type Checkpoint struct {
+	Strategy   string                           `json:"strategy"`
+	Version    string                           `json:"version"`
+	Resolution int64                            `json:"resolution"`
+	Charted    bool                             `json:"charted,omitempty"`
+	Symbols    map[string][]*ScenarioCheckpoint `json:"symbols"`
+	// Signature is the hmac of the checkpoint with the checkpoint key of the server, memory of
+	// checkpoints which were not signed by the server is never decoded
+	Signature []byte `json:"signature"`
+}
+
+// ScenarioCheckpoint holds the serialised memory of a scenario, see env.RegisterMemory
+type ScenarioCheckpoint struct {
+	Parameters []float64 `json:"parameters"`
+	LastTime   int64     `json:"lastTime"`
+	Memory     []byte    `json:"memory"`
+}
+
+// ContinueConfig evaluates scenarios like EvaluateConfig, scenarios found in the checkpoint by
+// symbol and parameters continue after it while others are simulated from the start
+type ContinueConfig struct {
+	EvaluateConfig
+	Checkpoint *Checkpoint `json:"checkpoint"`
+}
+
+// ContinueResult holds the events after the submitted checkpoint and the checkpoint to
+// continue from next time
+type ContinueResult struct {
+	Results    *algo.ResultSet `json:"results"`
+	Checkpoint *Checkpoint     `json:"checkpoint"`
+}
+
+// CheckpointVersionError is returned for checkpoints of another version of the strategy, the
+// layout of their memory may differ
+type CheckpointVersionError struct {
+	Checkpoint string
+	Strategy   string
+}
+
+func (e *CheckpointVersionError) Error() string {
+	return fmt.Sprintf("checkpoint of version %s cannot be continued by version %s", e.Checkpoint, e.Strategy)
+}
+
+// checkpointKey signs checkpoints, servers without a configured key sign with a random key so
+// their checkpoints can only be continued by the same process
+var checkpointKey = randomKey()
+
+func randomKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalln(err)
+	}
+	return key
+}
+
+// ErrCheckpointSignature rejects checkpoints which were altered or signed with another key
+var ErrCheckpointSignature = errors.New("checkpoint was not signed by this server")
+
+// ErrUnknownVersion rejects continuing checkpoints of strategies with an unknown version, the
+// memory layout of the build which created a checkpoint cannot be verified
+var ErrUnknownVersion = errors.New("continuing checkpoints requires the version of the strategy, build it with vcs information or set it with ritmic.SetVersion")
+
+// ErrChartedCheckpoint rejects checkpoints of strategies using derived charts, the strategy has
+// to be evaluated from the start instead
+var ErrChartedCheckpoint = errors.New("checkpoints of strategies using derived charts cannot be continued")
+
+func parametersKey(params []float64) string {
+	return fmt.Sprint(params)
+}
+
+// restoreStates matches the scenarios of a request with the checkpoint and restores their memory,
+// requested patterns and watchlists match the checkpoint by the symbols they resolve to
+func restoreStates(st *strategy, params *ContinueConfig) (map[string][]*simulation.ScenarioState, error) {
+
+	states := make(map[string][]*simulation.ScenarioState)
+	cp := params.Checkpoint
+	if cp == nil {
+		return states, nil
+	}
+	if st.Version == UnknownVersion {
+		return nil, ErrUnknownVersion
+	}
+	if !hmac.Equal(cp.Signature, cp.sign(checkpointKey)) {
+		return nil, ErrCheckpointSignature
+	}
+	if cp.Strategy != st.Name {
+		return nil, fmt.Errorf("checkpoint of strategy \"%s\" cannot be continued by \"%s\"", cp.Strategy, st.Name)
+	}
+	if cp.Resolution != params.Resolution {
+		return nil, fmt.Errorf("checkpoint resolution %d differs from %d", cp.Resolution, params.Resolution)
+	}
+	if cp.Version != st.Version {
+		return nil, &CheckpointVersionError{Checkpoint: cp.Version, Strategy: st.Version}
+	}
+	if cp.Charted {
+		return nil, ErrChartedCheckpoint
+	}
+
+	symbols, err := kiosk.ResolveSymbols(params.Symbols)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range symbols {
+		symbol := id.ToString()
+		found := make(map[string]*ScenarioCheckpoint)
+		for _, scenario := range cp.Symbols[symbol] {
+			if scenario != nil {
+				found[parametersKey(scenario.Parameters)] = scenario
+			}
+		}
+		if len(found) == 0 {
+			continue
+		}
+		restored := make([]*simulation.ScenarioState, len(params.Scenarios))
+		for i, scenario := range params.Scenarios {
+			saved, ok := found[parametersKey(scenario)]
+			if !ok {
+				continue
+			}
+			mem := env.NewMemory()
+			if err := mem.UnmarshalBinary(saved.Memory); err != nil {
+				return nil, fmt.Errorf("could not restore memory of %s scenario %d: %w", symbol, i, err)
+			}
+			restored[i] = &simulation.ScenarioState{
+				Results:  &algo.ScenarioSet{Events: make([]*algo.Event, 0), Parameters: scenario},
+				Memory:   mem,
+				LastTime: saved.LastTime,
+			}
+		}
+		states[symbol] = restored
+	}
+	return states, nil
+}
+
+// newCheckpoint serialises the states of every scenario after an evaluation
+func newCheckpoint(st *strategy, resolution int64, states map[string][]*simulation.ScenarioState) (*Checkpoint, error) {
+	cp := &Checkpoint{
+		Strategy:   st.Name,
+		Version:    st.Version,
+		Resolution: resolution,
+		Symbols:    make(map[string][]*ScenarioCheckpoint),
+	}
+	for symbol, scenarios := range states {
+		saved := make([]*ScenarioCheckpoint, len(scenarios))
+		for i, state := range scenarios {
+			cp.Charted = cp.Charted || state.Charted
+			mem, err := state.Memory.MarshalBinary()
+			if err != nil {
+				return nil, fmt.Errorf("could not serialise memory of %s scenario %d, register its types with env.RegisterMemory: %w", symbol, i, err)
+			}
+			saved[i] = &ScenarioCheckpoint{
+				Parameters: state.Results.Parameters,
+				LastTime:   state.LastTime,
+				Memory:     mem,
+			}
+		}
+		cp.Symbols[symbol] = saved
+	}
+	cp.Signature = cp.sign(checkpointKey)
+	return cp, nil
+}
+
+// sign computes the hmac of everything in the checkpoint but its signature, fields are length
+// prefixed and symbols are sorted so every checkpoint has a single encoding
+func (cp *Checkpoint) sign(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	write := func(values ...interface{}) {
+		for _, v := range values {
+			if b, ok := v.([]byte); ok {
+				_ = binary.Write(mac, binary.BigEndian, int64(len(b)))
+				_, _ = mac.Write(b)
+				continue
+			}
+			_ = binary.Write(mac, binary.BigEndian, v)
+		}
+	}
+	write([]byte(cp.Strategy), []byte(cp.Version), cp.Resolution, cp.Charted)
+	symbols := make([]string, 0, len(cp.Symbols))
+	for symbol := range cp.Symbols {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		write([]byte(symbol), int64(len(cp.Symbols[symbol])))
+		for _, scenario := range cp.Symbols[symbol] {
+			if scenario == nil {
+				write(false)
+				continue
+			}
+			write(true, int64(len(scenario.Parameters)), scenario.Parameters, scenario.LastTime, scenario.Memory)
+		}
+	}
+	return mac.Sum(nil)
+}
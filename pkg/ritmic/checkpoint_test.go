@@ -0,0 +1,220 @@
+package ritmic
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/godoji/algocore/internal/simulation"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"github.com/godoji/algocore/pkg/kiosk"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type checkpointMemory struct {
+	Trend int
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+
+	useMarket()
+	env.RegisterMemory(&checkpointMemory{})
+	st := &strategy{Name: "trend", Version: "v1"}
+
+	mem := env.NewMemory()
+	mem.Store(&checkpointMemory{Trend: 2})
+	cp, err := newCheckpoint(st, 60, map[string][]*simulation.ScenarioState{
+		"TEST:X:A": {
+			{Results: &algo.ScenarioSet{Parameters: []float64{1}}, Memory: mem, LastTime: 600},
+			{Results: &algo.ScenarioSet{Parameters: []float64{2}}, Memory: env.NewMemory(), LastTime: 600},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// checkpoints survive being sent to the client
+	data, _ := json.Marshal(cp)
+	cp = new(Checkpoint)
+	if err = json.Unmarshal(data, cp); err != nil {
+		panic(err)
+	}
+
+	// scenarios are matched by parameters, new symbols and scenarios start from scratch
+	states, err := restoreStates(st, &ContinueConfig{
+		EvaluateConfig: EvaluateConfig{
+			Symbols:    []string{"TEST:X:A", "TEST:X:B"},
+			Scenarios:  [][]float64{{3}, {1}},
+			Resolution: 60,
+		},
+		Checkpoint: cp,
+	})
+	if err != nil {
+		panic(err)
+	}
+	restored := states["TEST:X:A"]
+	if len(restored) != 2 || restored[0] != nil || restored[1] == nil || restored[1].LastTime != 600 {
+		fmt.Println("expected only the second scenario to continue")
+		t.FailNow()
+	}
+	if v, ok := restored[1].Memory.Read().(*checkpointMemory); !ok || v.Trend != 2 {
+		fmt.Println("expected memory to be restored")
+		t.Fail()
+	}
+	if _, ok := states["TEST:X:B"]; ok {
+		fmt.Println("expected symbol without checkpoint to start from scratch")
+		t.Fail()
+	}
+
+	if _, err = restoreStates(st, &ContinueConfig{EvaluateConfig: EvaluateConfig{Resolution: 3600}, Checkpoint: cp}); err == nil {
+		fmt.Println("expected checkpoint of another resolution to be rejected")
+		t.Fail()
+	}
+
+	// patterns match the checkpoint by the symbols they resolve to
+	states, err = restoreStates(st, &ContinueConfig{
+		EvaluateConfig: EvaluateConfig{Symbols: []string{"TEST:X:*"}, Scenarios: [][]float64{{1}}, Resolution: 60},
+		Checkpoint:     cp,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if len(states) != 1 || len(states["TEST:X:A"]) != 1 || states["TEST:X:A"][0] == nil {
+		fmt.Println("expected the checkpointed symbol of the pattern to continue")
+		t.Fail()
+	}
+
+	var symbolErrors kiosk.SymbolErrors
+	_, err = restoreStates(st, &ContinueConfig{EvaluateConfig: EvaluateConfig{Symbols: []string{"TEST:X:Z"}, Resolution: 60}, Checkpoint: cp})
+	if !errors.As(err, &symbolErrors) {
+		fmt.Printf("expected unknown symbols to be rejected but got %v\n", err)
+		t.Fail()
+	}
+
+	// memory of altered checkpoints is never decoded
+	continued := &ContinueConfig{EvaluateConfig: EvaluateConfig{Symbols: []string{"TEST:X:A"}, Scenarios: [][]float64{{1}}, Resolution: 60}, Checkpoint: cp}
+	cp.Symbols["TEST:X:A"][0].Memory = append(cp.Symbols["TEST:X:A"][0].Memory, 0)
+	if _, err = restoreStates(st, continued); err != ErrCheckpointSignature {
+		fmt.Printf("expected altered checkpoint to be rejected but got %v\n", err)
+		t.Fail()
+	}
+	cp.Symbols["TEST:X:A"][0].Memory = cp.Symbols["TEST:X:A"][0].Memory[:len(cp.Symbols["TEST:X:A"][0].Memory)-1]
+	cp.Signature = cp.sign([]byte("other server"))
+	if _, err = restoreStates(st, continued); err != ErrCheckpointSignature {
+		fmt.Printf("expected checkpoint of another key to be rejected but got %v\n", err)
+		t.Fail()
+	}
+
+	// builds without a version cannot tell whether the memory layout changed
+	cp.Signature = cp.sign(checkpointKey)
+	if _, err = restoreStates(&strategy{Name: "trend", Version: UnknownVersion}, continued); err != ErrUnknownVersion {
+		fmt.Printf("expected checkpoints of an unknown version to be rejected but got %v\n", err)
+		t.Fail()
+	}
+	if _, err = restoreStates(st, continued); err != nil {
+		fmt.Printf("expected the signed checkpoint to continue but got %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestContinueVersionConflict(t *testing.T) {
+
+	state = newLifecycle()
+	Register("trend", noopStrategy, []string{"period"})
+	SetVersion("trend", "v2")
+	defer unregister("trend")
+
+	cp := &Checkpoint{Strategy: "trend", Version: "v1", Resolution: 60}
+	cp.Signature = cp.sign(checkpointKey)
+	body, _ := json.Marshal(&ContinueConfig{
+		EvaluateConfig: EvaluateConfig{Symbols: []string{"A:B:C"}, Scenarios: [][]float64{{1}}, Resolution: 60},
+		Checkpoint:     cp,
+	})
+	r := httptest.NewRequest(http.MethodPost, "/strategies/trend/continue", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router().ServeHTTP(w, r)
+	if w.Code != http.StatusConflict {
+		fmt.Printf("expected conflict for checkpoint of another version but got %d\n", w.Code)
+		t.Fail()
+	}
+}
+
+func TestContinueChartedConflict(t *testing.T) {
+
+	useMarket()
+	Register("charted", func(chart env.MarketSupplier, res *algo.ResultHandler, mem *env.Memory, params env.Parameters) {
+		if chart.Chart(env.HeikinAshi(86400)).Candle().Missing {
+			res.NewEvent("missing")
+		}
+	}, []string{})
+	SetVersion("charted", "v1")
+	defer unregister("charted")
+
+	continueWith := func(cp *Checkpoint) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(&ContinueConfig{
+			EvaluateConfig: EvaluateConfig{Symbols: []string{"TEST:X:A"}, Scenarios: [][]float64{{}}, Resolution: 86400},
+			Checkpoint:     cp,
+		})
+		r := httptest.NewRequest(http.MethodPost, "/strategies/charted/continue", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		router().ServeHTTP(w, r)
+		return w
+	}
+	w := continueWith(nil)
+	if w.Code != http.StatusOK {
+		fmt.Printf("continue failed with code %d: %s\n", w.Code, w.Body.String())
+		t.FailNow()
+	}
+	result := new(ContinueResult)
+	if err := json.NewDecoder(w.Body).Decode(result); err != nil {
+		panic(err)
+	}
+	if !result.Checkpoint.Charted {
+		fmt.Println("expected the checkpoint of a charting strategy to be marked")
+		t.Fail()
+	}
+
+	// bars of derived charts are not part of the checkpoint
+	if w = continueWith(result.Checkpoint); w.Code != http.StatusConflict {
+		fmt.Printf("expected conflict for a charted checkpoint but got %d\n", w.Code)
+		t.Fail()
+	}
+}
+
+func TestContinueSavesRun(t *testing.T) {
+
+	useMarket()
+	store, err := NewRunStore(t.TempDir())
+	if err != nil {
+		panic(err)
+	}
+	runs = store
+	defer func() { runs = nil }()
+	Register("trend", noopStrategy, []string{"period"})
+	defer unregister("trend")
+
+	body, _ := json.Marshal(&ContinueConfig{
+		EvaluateConfig: EvaluateConfig{Symbols: []string{"TEST:X:*"}, Scenarios: [][]float64{{1}}, Resolution: 86400},
+	})
+	r := httptest.NewRequest(http.MethodPost, "/strategies/trend/continue", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		fmt.Printf("continue failed with code %d: %s\n", w.Code, w.Body.String())
+		t.FailNow()
+	}
+
+	// continued runs are stored like evaluations
+	run, err := store.Get(w.Header().Get("X-Run-ID"))
+	if err != nil {
+		fmt.Printf("expected the continued run to be stored: %s\n", err.Error())
+		t.FailNow()
+	}
+	if run.Strategy != "trend" || len(run.Config.Symbols) != 1 || run.Config.Symbols[0] != "TEST:X:*" {
+		fmt.Printf("unexpected stored run %+v\n", *run)
+		t.Fail()
+	}
+}
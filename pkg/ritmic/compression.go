@@ -0,0 +1,81 @@
+package ritmic
+
+import (
+	"github.com/godoji/algocore/pkg/compression"
+	"io"
+	"net/http"
+)
+
+// compressionMiddleware decodes compressed request bodies and compresses responses with the
+// preferred encoding of the client, responses are compressed while they are written
+func compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// decode request bodies
+		if encoding := r.Header.Get("Content-Encoding"); encoding != "" && r.Body != nil {
+			if !compression.Supported(encoding) {
+				http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+				return
+			}
+			body, err := compression.NewReader(encoding, r.Body)
+			if err != nil {
+				http.Error(w, "could not decode body", http.StatusBadRequest)
+				return
+			}
+			defer func() { _ = body.Close() }()
+			r.Body = body
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		}
+
+		// compress responses
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := compression.Negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == compression.Identity || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressingWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+type compressingWriter struct {
+	http.ResponseWriter
+	encoding    string
+	encoder     io.WriteCloser
+	wroteHeader bool
+}
+
+func (w *compressingWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if code >= http.StatusOK && code != http.StatusNoContent && code != http.StatusNotModified {
+		encoder, err := compression.NewWriter(w.encoding, w.ResponseWriter)
+		if err == nil {
+			w.Header().Set("Content-Encoding", w.encoding)
+			w.Header().Del("Content-Length")
+			w.encoder = encoder
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressingWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.encoder == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.encoder.Write(b)
+}
+
+func (w *compressingWriter) close() {
+	if w.encoder != nil {
+		_ = w.encoder.Close()
+	}
+}
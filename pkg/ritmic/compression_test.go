@@ -0,0 +1,68 @@
+package ritmic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/godoji/algocore/pkg/compression"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompressionMiddleware(t *testing.T) {
+
+	// echo the decoded request body as json
+	handler := compressionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sendResponse(w, r, map[string]string{"body": string(body)})
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	buf := new(bytes.Buffer)
+	enc, _ := compression.NewWriter(compression.Zstd, buf)
+	_, _ = enc.Write([]byte("scenarios"))
+	_ = enc.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, server.URL, buf)
+	req.Header.Set("Content-Encoding", compression.Zstd)
+	req.Header.Set("Accept-Encoding", compression.Gzip)
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		panic(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.Header.Get("Content-Encoding") != compression.Gzip {
+		fmt.Printf("expected gzip response but got %q\n", resp.Header.Get("Content-Encoding"))
+		t.FailNow()
+	}
+	body, err := compression.NewReader(compression.Gzip, resp.Body)
+	if err != nil {
+		panic(err)
+	}
+	result := make(map[string]string)
+	if err = json.NewDecoder(body).Decode(&result); err != nil || result["body"] != "scenarios" {
+		fmt.Printf("unexpected response %v (%v)\n", result, err)
+		t.Fail()
+	}
+
+	// unknown encodings are rejected
+	req, _ = http.NewRequest(http.MethodPost, server.URL, bytes.NewReader([]byte("x")))
+	req.Header.Set("Content-Encoding", "br")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		panic(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		fmt.Printf("expected unsupported media type but got %d\n", resp.StatusCode)
+		t.Fail()
+	}
+}
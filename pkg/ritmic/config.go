@@ -0,0 +1,124 @@
+package ritmic
+
+import (
+	"flag"
+	"github.com/godoji/algocore/pkg/kiosk"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type serverConfig struct {
+	Kiosk kiosk.Config
+	// Workers is the global budget of workers shared by all requests
+	Workers int
+	// DrainTimeout bounds how long running requests may finish when shutting down
+	DrainTimeout time.Duration
+	// Coordinator mode shards evaluations over the Peers and workers registering themselves
+	Coordinator bool
+	Peers       []string
+	// RunsDir keeps the results of every evaluation, disabled when empty
+	RunsDir string
+	// ResultCache bounds the count of scenarios kept to continue repeated evaluations, disabled
+	// when zero. Their size in memory is not bounded
+	ResultCache int
+	// CheckpointKey signs checkpoints, a random key is used when empty
+	CheckpointKey string
+}
+
+// parseConfig binds the server and data layer configuration to command line flags with defaults
+// taken from environment variables, a leading "live" argument is accepted for compatibility
+func parseConfig(args []string) (*serverConfig, error) {
+
+	cfg := kiosk.DefaultConfig()
+	fs := flag.NewFlagSet("ritmic", flag.ContinueOnError)
+	fs.StringVar(&cfg.KioURL, "kio-url", cfg.KioURL, "candle service url")
+	fs.StringVar(&cfg.IncaURL, "inca-url", cfg.IncaURL, "indicator service url")
+	fs.StringVar(&cfg.AlgoURL, "algo-url", cfg.AlgoURL, "algorithm service url")
+	fs.BoolVar(&cfg.Live, "live", envBool("LIVE", cfg.Live), "skip upstream caches and expire cached data quickly")
+	fs.DurationVar(&cfg.LiveTTL, "live-ttl", envDuration("LIVE_TTL", cfg.LiveTTL), "time to live of cached data in live mode")
+	fs.DurationVar(&cfg.TTL, "cache-ttl", envDuration("CACHE_TTL", cfg.TTL), "time to live of cached data, zero never expires")
+	cacheSize := fs.Int64("cache-size", envInt64("CACHE_SIZE", cfg.CacheSize>>20), "memory cache size in MB")
+	fs.StringVar(&cfg.DiskCacheDir, "cache-dir", os.Getenv("CACHE_DIR"), "directory of the disk cache, disabled when empty")
+	diskCacheSize := fs.Int64("disk-cache-size", envInt64("DISK_CACHE_SIZE", cfg.DiskCacheSize>>20), "disk cache size in MB")
+	fs.DurationVar(&cfg.Timeout, "http-timeout", envDuration("HTTP_TIMEOUT", cfg.Timeout), "timeout of a single upstream request")
+	retries := fs.Int64("http-retries", envInt64("HTTP_RETRIES", int64(cfg.Retries)), "retries of failed upstream requests")
+	hostConcurrency := fs.Int64("host-concurrency", envInt64("HOST_CONCURRENCY", int64(cfg.HostConcurrency)), "maximum concurrent requests per upstream host")
+	maxInFlight := fs.Int64("max-in-flight", envInt64("MAX_IN_FLIGHT", int64(cfg.MaxInFlight)), "maximum concurrent upstream requests")
+	workers := fs.Int64("workers", envInt64("WORKERS", int64(runtime.NumCPU())), "workers shared by all evaluations")
+	drainTimeout := fs.Duration("drain-timeout", envDuration("DRAIN_TIMEOUT", 30*time.Second), "time running requests may finish on shutdown")
+	coordinator := fs.Bool("coordinator", envBool("COORDINATOR", false), "shard evaluations over remote workers")
+	runsDir := fs.String("runs-dir", os.Getenv("RUNS_DIR"), "directory storing the results of every evaluation, disabled when empty")
+	resultCache := fs.Int64("result-cache", envInt64("RESULT_CACHE", 0), "scenarios kept to continue repeated evaluations, disabled when zero")
+	checkpointKey := fs.String("checkpoint-key", os.Getenv("CHECKPOINT_KEY"), "secret signing checkpoints, checkpoints only continue on the same process when empty")
+	peers := fs.String("peers", os.Getenv("PEERS"), "comma separated urls of remote workers, implies coordinator mode")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.Arg(0) == "live" {
+		cfg.Live = true
+	}
+	cfg.CacheSize = *cacheSize << 20
+	cfg.DiskCacheSize = *diskCacheSize << 20
+	cfg.MaxInFlight = int(*maxInFlight)
+	cfg.Retries = int(*retries)
+	cfg.HostConcurrency = int(*hostConcurrency)
+
+	peerUrls := make([]string, 0)
+	for _, peer := range strings.Split(*peers, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			peerUrls = append(peerUrls, peer)
+		}
+	}
+
+	return &serverConfig{
+		Kiosk:         cfg,
+		Workers:       int(*workers),
+		DrainTimeout:  *drainTimeout,
+		Coordinator:   *coordinator || len(peerUrls) > 0,
+		Peers:         peerUrls,
+		RunsDir:       *runsDir,
+		ResultCache:   int(*resultCache),
+		CheckpointKey: *checkpointKey,
+	}, nil
+}
+
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("invalid value for %s: %s\n", key, v)
+	}
+	return b
+}
+
+func envInt64(key string, def int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		log.Fatalf("invalid value for %s: %s\n", key, v)
+	}
+	return i
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid value for %s: %s\n", key, v)
+	}
+	return d
+}
@@ -0,0 +1,47 @@
+package ritmic
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestKioskConfig(t *testing.T) {
+
+	server, err := parseConfig([]string{"-cache-size", "64", "-cache-ttl", "1m", "-workers", "3", "live"})
+	if err != nil {
+		panic(err)
+	}
+	cfg := server.Kiosk
+	if !cfg.Live {
+		fmt.Println("expected live mode from positional argument")
+		t.Fail()
+	}
+	if cfg.CacheSize != 64<<20 {
+		fmt.Printf("expected cache size %d but got %d\n", 64<<20, cfg.CacheSize)
+		t.Fail()
+	}
+	if cfg.TTL != time.Minute {
+		fmt.Printf("expected ttl %s but got %s\n", time.Minute, cfg.TTL)
+		t.Fail()
+	}
+
+	if server.Workers != 3 {
+		fmt.Printf("expected 3 workers but got %d\n", server.Workers)
+		t.Fail()
+	}
+
+	server, err = parseConfig([]string{"-peers", "http://a:8071, http://b:8071"})
+	if err != nil {
+		panic(err)
+	}
+	if !server.Coordinator || len(server.Peers) != 2 || server.Peers[1] != "http://b:8071" {
+		fmt.Printf("expected coordinator mode with two peers but got %v\n", server.Peers)
+		t.Fail()
+	}
+
+	if _, err = parseConfig([]string{"-cache-size", "big"}); err == nil {
+		fmt.Println("expected invalid cache size to be rejected")
+		t.Fail()
+	}
+}
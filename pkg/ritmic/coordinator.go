@@ -0,0 +1,402 @@
+package ritmic
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/compression"
+	"github.com/godoji/algocore/pkg/kiosk"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoWorkers = errors.New("no healthy workers available")
+
+// WorkerError is returned when a worker rejects a shard, these requests are not retried
+type WorkerError struct {
+	URL  string
+	Code int
+	Body []byte
+}
+
+func (e *WorkerError) Error() string {
+	return fmt.Sprintf("worker %s rejected shard with code %d: %s", e.URL, e.Code, strings.TrimSpace(string(e.Body)))
+}
+
+// WorkerStatus describes a registered worker
+type WorkerStatus struct {
+	URL     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+	Active  int    `json:"active"`
+}
+
+type remoteWorker struct {
+	url     string
+	healthy bool
+	active  int
+}
+
+// Coordinator shards evaluations over remote algocore workers and merges their results,
+// failed shards are retried on other workers
+type Coordinator struct {
+	// SymbolsPerShard and ScenariosPerShard bound the size of a single shard
+	SymbolsPerShard   int
+	ScenariosPerShard int
+	// ShardsPerWorker bounds the shards sent to a worker at once
+	ShardsPerWorker int
+	// Retries of a shard after a worker failed to evaluate it
+	Retries int
+	// Timeout of a single shard request including reading its results, a worker which does
+	// not answer in time is treated as failed
+	Timeout time.Duration
+	http    *http.Client
+	lock    sync.Mutex
+	changed *sync.Cond
+	workers map[string]*remoteWorker
+}
+
+type shard struct {
+	symbols   []string
+	offset    int
+	scenarios [][]float64
+}
+
+func NewCoordinator(urls []string) *Coordinator {
+	c := &Coordinator{
+		SymbolsPerShard:   8,
+		ScenariosPerShard: 1000,
+		ShardsPerWorker:   2,
+		Retries:           3,
+		Timeout:           30 * time.Minute,
+		http:              &http.Client{},
+		workers:           make(map[string]*remoteWorker),
+	}
+	c.changed = sync.NewCond(&c.lock)
+	for _, address := range urls {
+		c.Register(address)
+	}
+	return c
+}
+
+// Register adds a worker, it receives shards until a heartbeat or shard fails
+func (c *Coordinator) Register(address string) {
+	address = strings.TrimSuffix(address, "/")
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if w, ok := c.workers[address]; ok {
+		w.healthy = true
+	} else {
+		c.workers[address] = &remoteWorker{url: address, healthy: true}
+	}
+	c.changed.Broadcast()
+}
+
+func (c *Coordinator) Unregister(address string) {
+	address = strings.TrimSuffix(address, "/")
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.workers, address)
+	c.changed.Broadcast()
+}
+
+func (c *Coordinator) Workers() []*WorkerStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	result := make([]*WorkerStatus, 0, len(c.workers))
+	for _, w := range c.workers {
+		result = append(result, &WorkerStatus{URL: w.url, Healthy: w.healthy, Active: w.active})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].URL < result[j].URL
+	})
+	return result
+}
+
+func (c *Coordinator) setHealthy(w *remoteWorker, healthy bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if w.healthy != healthy {
+		log.Printf("worker %s healthy: %t\n", w.url, healthy)
+	}
+	w.healthy = healthy
+	c.changed.Broadcast()
+}
+
+// CheckHealth sends a heartbeat to every registered worker
+func (c *Coordinator) CheckHealth() {
+	c.lock.Lock()
+	workers := make([]*remoteWorker, 0, len(c.workers))
+	for _, w := range c.workers {
+		workers = append(workers, w)
+	}
+	c.lock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w *remoteWorker) {
+			defer wg.Done()
+			c.setHealthy(w, c.heartbeat(w))
+		}(w)
+	}
+	wg.Wait()
+}
+
+func (c *Coordinator) heartbeat(w *remoteWorker) bool {
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(w.url + "/heartbeat")
+	if err != nil {
+		return false
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// Watch checks the health of all workers at a fixed interval until stop is closed
+func (c *Coordinator) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.CheckHealth()
+		}
+	}
+}
+
+// acquire waits for a healthy worker with spare capacity, workers which already failed the
+// shard are only used when no other worker is healthy
+func (c *Coordinator) acquire(tried map[string]bool) (*remoteWorker, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for {
+		var best *remoteWorker
+		healthy, untried := 0, 0
+		for _, w := range c.workers {
+			if !w.healthy {
+				continue
+			}
+			healthy++
+			if !tried[w.url] {
+				untried++
+			}
+		}
+		if healthy == 0 {
+			return nil, ErrNoWorkers
+		}
+		for _, w := range c.workers {
+			if !w.healthy || w.active >= c.ShardsPerWorker || (untried > 0 && tried[w.url]) {
+				continue
+			}
+			if best == nil || w.active < best.active || (w.active == best.active && w.url < best.url) {
+				best = w
+			}
+		}
+		if best != nil {
+			best.active++
+			return best, nil
+		}
+		c.changed.Wait()
+	}
+}
+
+func (c *Coordinator) release(w *remoteWorker) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	w.active--
+	c.changed.Broadcast()
+}
+
+// Evaluate splits the request into shards of symbols and scenarios and merges the results,
+// without a strategy name the default strategy of the workers is evaluated. Aliases, watchlists
+// and wildcards are resolved before sharding, unknown symbols are reported as kiosk.SymbolErrors
+func (c *Coordinator) Evaluate(name string, params *EvaluateConfig) (*algo.ResultSet, error) {
+
+	path := "/evaluate"
+	if name != "" {
+		path = "/strategies/" + url.PathEscape(name) + "/evaluate"
+	}
+
+	assets, err := kiosk.ResolveSymbols(params.Symbols)
+	if err != nil {
+		return nil, err
+	}
+	symbols := make([]string, len(assets))
+	for i, asset := range assets {
+		symbols[i] = asset.ToString()
+	}
+
+	shards := c.split(symbols, params.Scenarios)
+	results := make([]*algo.ResultSet, len(shards))
+	errs := make([]error, len(shards))
+
+	var wg sync.WaitGroup
+	for i := range shards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = c.evaluateShard(path, shards[i], params)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return mergeResults(shards, results, len(params.Scenarios)), nil
+}
+
+// split shards resolved symbols, every symbol is evaluated by exactly one shard per scenario
+func (c *Coordinator) split(symbols []string, scenarios [][]float64) []*shard {
+	symbolsPerShard := c.SymbolsPerShard
+	if symbolsPerShard < 1 {
+		symbolsPerShard = 1
+	}
+	scenariosPerShard := c.ScenariosPerShard
+	if scenariosPerShard < 1 {
+		scenariosPerShard = 1
+	}
+	shards := make([]*shard, 0)
+	for i := 0; i < len(symbols); i += symbolsPerShard {
+		part := symbols[i:minInt(i+symbolsPerShard, len(symbols))]
+		for j := 0; j < len(scenarios) || j == 0; j += scenariosPerShard {
+			shards = append(shards, &shard{
+				symbols:   part,
+				offset:    j,
+				scenarios: scenarios[j:minInt(j+scenariosPerShard, len(scenarios))],
+			})
+		}
+	}
+	return shards
+}
+
+func (c *Coordinator) evaluateShard(path string, sh *shard, params *EvaluateConfig) (*algo.ResultSet, error) {
+
+	body, err := json.Marshal(&EvaluateConfig{
+		Symbols:    sh.symbols,
+		Scenarios:  sh.scenarios,
+		Resolution: params.Resolution,
+		Priority:   params.Priority,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tried := make(map[string]bool)
+	for attempt := 0; ; attempt++ {
+		w, err := c.acquire(tried)
+		if err != nil {
+			return nil, err
+		}
+		tried[w.url] = true
+		result, err := c.send(w, path, body)
+		c.release(w)
+		if err == nil {
+			return result, nil
+		}
+
+		// rejected shards fail the same way on every worker
+		var workerErr *WorkerError
+		if errors.As(err, &workerErr) {
+			return nil, err
+		}
+
+		log.Printf("shard failed on worker %s: %s\n", w.url, err.Error())
+		c.setHealthy(w, false)
+		if attempt >= c.Retries {
+			return nil, fmt.Errorf("giving up shard after %d attempts: %w", attempt+1, err)
+		}
+	}
+}
+
+func (c *Coordinator) send(w *remoteWorker, path string, body []byte) (*algo.ResultSet, error) {
+
+	ctx := context.Background()
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", algo.BinaryMediaType+", application/octet-stream;q=0.9")
+	req.Header.Set("Accept-Encoding", compression.AcceptEncoding)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if resp.Body, err = compression.NewReader(resp.Header.Get("Content-Encoding"), resp.Body); err != nil {
+		return nil, fmt.Errorf("decoding response failed: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode < http.StatusInternalServerError {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, &WorkerError{URL: w.url, Code: resp.StatusCode, Body: msg}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("evaluation failed with code %d", resp.StatusCode)
+	}
+
+	// workers without the binary format answer with gob
+	if resp.Header.Get("Content-Type") == algo.BinaryMediaType {
+		result, err := algo.DecodeResultSet(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("decoding results failed: %w", err)
+		}
+		return result, nil
+	}
+	result := new(algo.ResultSet)
+	if err = gob.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("decoding results failed: %w", err)
+	}
+	return result, nil
+}
+
+// mergeResults places the scenarios of every shard at their offset in the full result
+func mergeResults(shards []*shard, results []*algo.ResultSet, scenarios int) *algo.ResultSet {
+	merged := &algo.ResultSet{Symbols: make(map[string]*algo.SymbolResultSet)}
+	for i, sh := range shards {
+		for symbol, part := range results[i].Symbols {
+			dst, ok := merged.Symbols[symbol]
+			if !ok {
+				dst = &algo.SymbolResultSet{Scenarios: make([]*algo.ScenarioSet, scenarios)}
+				merged.Symbols[symbol] = dst
+			}
+			copy(dst.Scenarios[sh.offset:], part.Scenarios)
+			if part.LastTime > dst.LastTime {
+				dst.LastTime = part.LastTime
+			}
+		}
+	}
+	return merged
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,149 @@
+package ritmic
+
+import (
+	"fmt"
+	"github.com/godoji/algocore/internal/kiosktest"
+	"github.com/godoji/algocore/internal/simulation"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type crossMemory struct {
+	Above bool
+}
+
+// crossStrategy emits an event whenever the price crosses the threshold parameter
+func crossStrategy(chart env.MarketSupplier, res *algo.ResultHandler, mem *env.Memory, params env.Parameters) {
+	store, ok := mem.Read().(*crossMemory)
+	if !ok {
+		store = new(crossMemory)
+	}
+	above := chart.Price() > params.Get("threshold")
+	if above && !store.Above {
+		res.NewEvent("up")
+	} else if !above && store.Above {
+		res.NewEvent("down")
+	}
+	store.Above = above
+	mem.Store(store)
+}
+
+// useMarket evaluates strategies in process against the synthetic market
+func useMarket() {
+	kiosktest.Setup()
+	state = newLifecycle()
+	if pool == nil {
+		pool = NewWorkerPool(4)
+	}
+}
+
+// flakyWorker fails the first evaluations before passing them on to the worker
+func flakyWorker(worker http.Handler, failures *int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/evaluate") && atomic.AddInt64(failures, -1) >= 0 {
+			http.Error(w, "worker crashed", http.StatusInternalServerError)
+			return
+		}
+		worker.ServeHTTP(w, r)
+	})
+}
+
+func TestCoordinatorEvaluate(t *testing.T) {
+
+	useMarket()
+	Register("cross", crossStrategy, []string{"threshold"})
+	defer unregister("cross")
+
+	failures := int64(2)
+	healthy := httptest.NewServer(router())
+	defer healthy.Close()
+	flaky := httptest.NewServer(flakyWorker(router(), &failures))
+	defer flaky.Close()
+	dead := httptest.NewServer(router())
+	dead.Close()
+
+	c := NewCoordinator([]string{healthy.URL, flaky.URL, dead.URL})
+	c.SymbolsPerShard = 2
+	c.ScenariosPerShard = 2
+	c.CheckHealth()
+
+	for _, status := range c.Workers() {
+		if status.Healthy != (status.URL != dead.URL) {
+			fmt.Printf("unexpected health of worker %s: %t\n", status.URL, status.Healthy)
+			t.Fail()
+		}
+	}
+
+	// patterns are resolved and duplicates evaluated once
+	params := &EvaluateConfig{
+		Symbols:    []string{"TEST:X:*", "TEST:X:A"},
+		Scenarios:  [][]float64{{95}, {100}, {105}, {110}, {115}},
+		Resolution: 86400,
+	}
+	results, err := c.Evaluate("cross", params)
+	if err != nil {
+		panic(err)
+	}
+	if len(results.Symbols) != len(kiosktest.Symbols) {
+		fmt.Printf("expected %d symbols but got %d\n", len(kiosktest.Symbols), len(results.Symbols))
+		t.FailNow()
+	}
+
+	// merged shards equal an evaluation by a single worker
+	evaluator, err := simulation.NewEvaluator(simulation.EvalOptions{
+		Step:       crossStrategy,
+		Resolution: params.Resolution,
+		Symbols:    kiosktest.Symbols,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if err = evaluator.Run(params.Scenarios, []string{"threshold"}); err != nil {
+		panic(err)
+	}
+	expected := evaluator.Results()
+	for _, symbol := range kiosktest.Symbols {
+		set, ok := results.Symbols[symbol]
+		if !ok || len(set.Scenarios) != len(params.Scenarios) {
+			fmt.Printf("missing scenarios of %s\n", symbol)
+			t.Fail()
+			continue
+		}
+		for i, scenario := range set.Scenarios {
+			want := expected.Symbols[symbol].Scenarios[i]
+			if scenario == nil || scenario.Parameters[0] != params.Scenarios[i][0] || len(scenario.Events) != len(want.Events) {
+				fmt.Printf("scenario %d of %s was merged incorrectly\n", i, symbol)
+				t.Fail()
+				continue
+			}
+			for j, e := range scenario.Events {
+				if e.CreatedOn != want.Events[j].CreatedOn || e.Label != want.Events[j].Label {
+					fmt.Printf("event %d of scenario %d of %s differs\n", j, i, symbol)
+					t.Fail()
+					break
+				}
+			}
+		}
+	}
+
+	// unknown symbols are rejected before any shard is sent
+	if _, err = c.Evaluate("cross", &EvaluateConfig{Symbols: []string{"TEST:X:Z"}, Resolution: 86400}); err == nil {
+		fmt.Println("expected unknown symbol to be rejected")
+		t.Fail()
+	}
+}
+
+func TestCoordinatorNoWorkers(t *testing.T) {
+	useMarket()
+	c := NewCoordinator(nil)
+	_, err := c.Evaluate("", &EvaluateConfig{Symbols: kiosktest.Symbols[:1], Resolution: 60})
+	if err != ErrNoWorkers {
+		fmt.Printf("expected no workers error but got %v\n", err)
+		t.Fail()
+	}
+}
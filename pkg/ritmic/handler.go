@@ -1,13 +1,18 @@
 package ritmic
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"github.com/godoji/algocore/internal/simulation"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/kiosk"
 	"github.com/gorilla/mux"
+	"github.com/northberg/candlestick"
 	"log"
 	"net/http"
-	"sync"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,72 +20,570 @@ type EvaluateConfig struct {
 	Symbols    []string    `json:"symbols"`
 	Scenarios  [][]float64 `json:"scenarios"`
 	Resolution int64       `json:"resolution"`
+	Priority   int         `json:"priority"`
 }
 
-var wg sync.WaitGroup
-var isTerminating = false
+// ScreenConfig evaluates scenarios like EvaluateConfig over a short history up to Time
+type ScreenConfig struct {
+	EvaluateConfig
+	Time   int64 `json:"time"`
+	Warmup int64 `json:"warmup"`
+}
+
+type SymbolInfo struct {
+	Symbol      string `json:"symbol"`
+	Broker      string `json:"broker"`
+	Exchange    string `json:"exchange"`
+	Ticker      string `json:"ticker"`
+	OnBoardDate int64  `json:"onBoardDate"`
+}
 
 func handleTerminate(w http.ResponseWriter, _ *http.Request) {
-	isTerminating = true
-	wg.Wait()
+	drained := state.drain(drainTimeout)
+	if !drained {
+		log.Printf("running requests did not finish within %s\n", drainTimeout)
+	}
 	w.WriteHeader(http.StatusOK)
 	go func() {
 		time.Sleep(10 * time.Millisecond)
-		if err := srv.Shutdown(context.Background()); err != nil {
-			log.Fatal("Server shutdown failed: ", err)
-		}
+		shutdown()
 	}()
 }
 
 func handleEvaluate(w http.ResponseWriter, r *http.Request) {
 
-	// Add to wait group
-	wg.Add(1)
-	defer wg.Done()
-
-	// Check if the stop signal has been received
-	if isTerminating {
+	// Reject new work once the server is shutting down
+	if !state.begin() {
 		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer state.end()
+
+	// Find the requested strategy
+	st, ok := requestedStrategy(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request parameters
+	params := new(EvaluateConfig)
+	if !readEvaluation(w, r, params, params) {
+		return
 	}
 
+	// Shard the evaluation over remote workers in coordinator mode
+	if coordinator != nil {
+		handleCoordinated(w, r, st, mux.Vars(r)["name"], params)
+		return
+	}
+
+	// Run the simulation, continuing cached scenarios of the same version
+	evaluator, ok := evaluate(w, st, params, func(evaluator *simulation.Evaluator) {
+		if resultCache != nil {
+			evaluator.SetResultCache(resultCache, st.Version)
+		}
+	})
+	if !ok {
+		return
+	}
+
+	// Send back the results as a sync request
+	saveRun(w, st, params, evaluator.Metrics(), evaluator.Results())
+	sendResponse(w, r, evaluator.Results())
+}
+
+// readEvaluation parses the body of an evaluation request into body and checks the evaluation
+// it holds, it answers the request itself when the evaluation is invalid
+func readEvaluation(w http.ResponseWriter, r *http.Request, body interface{}, params *EvaluateConfig) bool {
+
 	// Check the request body
 	if r.Body == nil {
 		http.Error(w, "no body", http.StatusBadRequest)
-		return
+		return false
 	}
 
 	// Parse request parameters
-	params := new(EvaluateConfig)
-	err := json.NewDecoder(r.Body).Decode(params)
-	if err != nil {
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil {
 		http.Error(w, "could not parse variables", http.StatusBadRequest)
-		return
+		return false
 	}
 	if len(params.Symbols) < 1 {
 		http.Error(w, "there must be at least 1 symbol", http.StatusBadRequest)
-		return
+		return false
 	}
 	if params.Resolution == 0 {
 		http.Error(w, "invalid resolution", http.StatusBadRequest)
-		return
+		return false
 	}
+	return true
+}
+
+// evaluate runs the scenarios of a request on the shared workers, configure sets up the
+// evaluator before it runs. It answers the request itself when the evaluation fails
+func evaluate(w http.ResponseWriter, st *strategy, params *EvaluateConfig, configure func(evaluator *simulation.Evaluator)) (*simulation.Evaluator, bool) {
 
 	// Create an evaluator to run requested scenario
-	evaluator := simulation.NewEvaluator(simulation.EvalOptions{
-		Step:       s.Evaluator,
+	evaluator, err := simulation.NewEvaluator(simulation.EvalOptions{
+		Step:       st.Evaluator,
 		Resolution: params.Resolution,
 		Symbols:    params.Symbols,
+		Name:       st.Name,
 	})
+	var symbolErrors kiosk.SymbolErrors
+	if errors.As(err, &symbolErrors) {
+		sendErrors(w, http.StatusBadRequest, symbolErrors)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), upstreamStatus(err))
+		return nil, false
+	}
 
-	// Run the simulation with given parameters
-	evaluator.SetMaxThreads(4)
-	evaluator.Run(params.Scenarios, s.ParamKeys)
+	// Refuse strategies which depend on themselves
+	if _, err = simulation.DependencyOrder(st.Name, dependency); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
 
-	// Send back the results as a sync request
-	sendResponse(w, r, evaluator.Results())
+	// Run the simulation with given parameters on the shared workers
+	evaluator.SetThreadSplit(pool.Size(), len(params.Scenarios))
+	evaluator.SetSlots(pool.Client(params.Priority))
+	evaluator.SetDependencies(dependency)
+	configure(evaluator)
+	err = evaluator.Run(params.Scenarios, st.ParamKeys)
+	discover(evaluator.Discovered())
+	if err != nil {
+		http.Error(w, err.Error(), upstreamStatus(err))
+		return nil, false
+	}
+	return evaluator, true
+}
+
+// upstreamStatus is the status of a request failing on the data services, an open circuit
+// breaker means the service is unavailable for a while
+func upstreamStatus(err error) int {
+	if errors.Is(err, kiosk.ErrCircuitOpen) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+func handleCoordinated(w http.ResponseWriter, r *http.Request, st *strategy, name string, params *EvaluateConfig) {
+	status := &algo.Status{StartTime: time.Now().UTC().UnixMilli(), Running: true}
+	results, err := coordinator.Evaluate(name, params)
+	var symbolErrors kiosk.SymbolErrors
+	if errors.As(err, &symbolErrors) {
+		sendErrors(w, http.StatusBadRequest, symbolErrors)
+		return
+	}
+	var workerErr *WorkerError
+	if errors.As(err, &workerErr) {
+		// pass rejections such as unknown symbols on to the client
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(workerErr.Code)
+		_, _ = w.Write(workerErr.Body)
+		return
+	}
+	if errors.Is(err, ErrNoWorkers) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	status.Elapsed = time.Now().UTC().UnixMilli() - status.StartTime
+	status.Running = false
+	status.Finished = true
+	saveRun(w, st, params, status, results)
+	sendResponse(w, r, results)
+}
+
+// saveRun keeps the results when a run store is configured and tells the client the id of the run
+func saveRun(w http.ResponseWriter, st *strategy, params *EvaluateConfig, status *algo.Status, results *algo.ResultSet) {
+	if runs == nil {
+		return
+	}
+	run := &RunInfo{Strategy: st.Name, Version: st.Version, Config: params, Status: status}
+	if err := runs.Save(run, results); err != nil {
+		log.Printf("could not store run: %s\n", err.Error())
+		return
+	}
+	w.Header().Set("X-Run-ID", run.ID)
+}
+
+// handleContinue evaluates like handleEvaluate but continues the scenarios of a checkpoint with
+// new candles and answers with the events after it together with the next checkpoint
+func handleContinue(w http.ResponseWriter, r *http.Request) {
+
+	// Reject new work once the server is shutting down
+	if !state.begin() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer state.end()
+
+	// Find the requested strategy
+	st, ok := requestedStrategy(w, r)
+	if !ok {
+		return
+	}
+	if coordinator != nil {
+		http.Error(w, "continuing checkpoints is not supported in coordinator mode", http.StatusNotImplemented)
+		return
+	}
+
+	// Parse request parameters
+	params := new(ContinueConfig)
+	if !readEvaluation(w, r, params, &params.EvaluateConfig) {
+		return
+	}
+
+	// Restore the memory of checkpointed scenarios
+	states, err := restoreStates(st, params)
+	if errors.Is(err, ErrUnknownVersion) {
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	}
+	var versionErr *CheckpointVersionError
+	if errors.As(err, &versionErr) || errors.Is(err, ErrCheckpointSignature) || errors.Is(err, ErrChartedCheckpoint) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	var symbolErrors kiosk.SymbolErrors
+	if errors.As(err, &symbolErrors) {
+		sendErrors(w, http.StatusBadRequest, symbolErrors)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Run the simulation over the new candles
+	evaluator, ok := evaluate(w, st, &params.EvaluateConfig, func(evaluator *simulation.Evaluator) {
+		evaluator.Resume(states)
+	})
+	if !ok {
+		return
+	}
+
+	checkpoint, err := newCheckpoint(st, params.Resolution, evaluator.States())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	saveRun(w, st, &params.EvaluateConfig, evaluator.Metrics(), evaluator.Results())
+	sendResponse(w, r, &ContinueResult{Results: evaluator.Results(), Checkpoint: checkpoint})
+}
+
+func handleScreen(w http.ResponseWriter, r *http.Request) {
+
+	// Reject new work once the server is shutting down
+	if !state.begin() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer state.end()
+
+	// Find the requested strategy
+	st, ok := requestedStrategy(w, r)
+	if !ok {
+		return
+	}
+
+	// Parse request parameters
+	params := new(ScreenConfig)
+	if !readEvaluation(w, r, params, &params.EvaluateConfig) {
+		return
+	}
+
+	// Screen a short history up to the requested time, one block of candles by default
+	at := params.Time
+	if at == 0 {
+		at = time.Now().UTC().Unix()
+	}
+	warmup := params.Warmup
+	if warmup <= 0 {
+		warmup = params.Resolution * candlestick.CandleSetSize
+	}
+
+	// Create an evaluator over the universe
+	evaluator, err := simulation.NewEvaluator(simulation.EvalOptions{
+		Step:       st.Evaluator,
+		Resolution: params.Resolution,
+		Symbols:    params.Symbols,
+		From:       at - warmup,
+		Until:      at,
+		Name:       st.Name,
+	})
+	var symbolErrors kiosk.SymbolErrors
+	if errors.As(err, &symbolErrors) {
+		sendErrors(w, http.StatusBadRequest, symbolErrors)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), upstreamStatus(err))
+		return
+	}
+
+	// Refuse strategies which depend on themselves
+	if _, err = simulation.DependencyOrder(st.Name, dependency); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Run the screen on the shared workers and send back symbols with current events
+	evaluator.SetThreadSplit(pool.Size(), len(params.Scenarios))
+	evaluator.SetSlots(pool.Client(params.Priority))
+	evaluator.SetDependencies(dependency)
+	result, err := evaluator.Screen(params.Scenarios, st.ParamKeys)
+	discover(evaluator.Discovered())
+	if err != nil {
+		http.Error(w, err.Error(), upstreamStatus(err))
+		return
+	}
+	sendResponse(w, r, result)
+}
+
+// requestedStrategy resolves the strategy of the route, routes without a name use the
+// default strategy
+func requestedStrategy(w http.ResponseWriter, r *http.Request) (*strategy, bool) {
+	name := mux.Vars(r)["name"]
+	st, ok := lookupStrategy(name)
+	if !ok && name == "" {
+		http.Error(w, "multiple strategies are hosted, use /strategies/{name}", http.StatusNotFound)
+		return nil, false
+	}
+	if !ok {
+		http.Error(w, "unknown strategy", http.StatusNotFound)
+		return nil, false
+	}
+	return st, true
+}
+
+func handleStrategies(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, r, listStrategies())
+}
+
+func handleDependencies(w http.ResponseWriter, r *http.Request) {
+	sendResponse(w, r, listDependencies())
+}
+
+func handleSymbols(w http.ResponseWriter, r *http.Request) {
+
+	// Parse optional filters
+	query := r.URL.Query()
+	broker := query.Get("broker")
+	exchange := query.Get("exchange")
+	var onBoardBefore, onBoardAfter int64
+	var err error
+	if v := query.Get("onBoardBefore"); v != "" {
+		if onBoardBefore, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "invalid onBoardBefore", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("onBoardAfter"); v != "" {
+		if onBoardAfter, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "invalid onBoardAfter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Retrieve the universe from the market service
+	symbols, err := kiosk.ListSymbols()
+	if err != nil {
+		http.Error(w, err.Error(), upstreamStatus(err))
+		return
+	}
+
+	result := make([]*SymbolInfo, 0)
+	for symbol, info := range symbols {
+		xs := strings.Split(symbol, ":")
+		if len(xs) != 3 {
+			continue
+		}
+		if broker != "" && xs[0] != broker {
+			continue
+		}
+		if exchange != "" && xs[1] != exchange {
+			continue
+		}
+		if onBoardBefore != 0 && info.OnBoardDate >= onBoardBefore {
+			continue
+		}
+		if onBoardAfter != 0 && info.OnBoardDate <= onBoardAfter {
+			continue
+		}
+		result = append(result, &SymbolInfo{
+			Symbol:      symbol,
+			Broker:      xs[0],
+			Exchange:    xs[1],
+			Ticker:      xs[2],
+			OnBoardDate: info.OnBoardDate,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Symbol < result[j].Symbol
+	})
+
+	sendResponse(w, r, result)
+}
+
+func handleRuns(w http.ResponseWriter, r *http.Request) {
+	if runs == nil {
+		http.Error(w, "run store is disabled", http.StatusNotFound)
+		return
+	}
+	result, err := runs.List(r.URL.Query().Get("strategy"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sendResponse(w, r, result)
+}
+
+func handleRun(w http.ResponseWriter, r *http.Request) {
+	if runs == nil {
+		http.Error(w, "run store is disabled", http.StatusNotFound)
+		return
+	}
+	run, err := runs.Get(mux.Vars(r)["id"])
+	if !sendRunError(w, err) {
+		sendResponse(w, r, run)
+	}
+}
+
+func handleRunResults(w http.ResponseWriter, r *http.Request) {
+	if runs == nil {
+		http.Error(w, "run store is disabled", http.StatusNotFound)
+		return
+	}
+	results, err := runs.Results(mux.Vars(r)["id"])
+	if !sendRunError(w, err) {
+		sendResponse(w, r, results)
+	}
+}
+
+func handleDeleteRun(w http.ResponseWriter, r *http.Request) {
+	if runs == nil {
+		http.Error(w, "run store is disabled", http.StatusNotFound)
+		return
+	}
+	if !sendRunError(w, runs.Delete(mux.Vars(r)["id"])) {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleRunDiff lists the events which changed per symbol and scenario between two runs, runs
+// of an unknown version are not known to be of the same build
+func handleRunDiff(w http.ResponseWriter, r *http.Request) {
+	if runs == nil {
+		http.Error(w, "run store is disabled", http.StatusNotFound)
+		return
+	}
+	vars := mux.Vars(r)
+	fromRun, err := runs.Get(vars["id"])
+	if sendRunError(w, err) {
+		return
+	}
+	toRun, err := runs.Get(vars["other"])
+	if sendRunError(w, err) {
+		return
+	}
+	from, err := runs.Results(vars["id"])
+	if sendRunError(w, err) {
+		return
+	}
+	to, err := runs.Results(vars["other"])
+	if sendRunError(w, err) {
+		return
+	}
+	sendResponse(w, r, &RunDiff{
+		From:        vars["id"],
+		To:          vars["other"],
+		FromVersion: fromRun.Version,
+		ToVersion:   toRun.Version,
+		Scenarios:   DiffResults(from, to),
+	})
+}
+
+// sendRunError answers with the error of a run store operation, it returns false without error
+func sendRunError(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, ErrRunNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return true
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	return false
+}
+
+type ServerMetrics struct {
+	*kiosk.CacheMetrics
+	Workers *PoolMetrics                     `json:"workers"`
+	Results *simulation.ScenarioCacheMetrics `json:"results,omitempty"`
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics := &ServerMetrics{
+		CacheMetrics: kiosk.Metrics(),
+		Workers:      pool.Metrics(),
+	}
+	if resultCache != nil {
+		metrics.Results = resultCache.Metrics()
+	}
+	sendResponse(w, r, metrics)
+}
+
+type WorkerRegistration struct {
+	URL string `json:"url"`
+}
+
+func handleRegisterWorker(w http.ResponseWriter, r *http.Request) {
+	if coordinator == nil {
+		http.Error(w, "not running as coordinator", http.StatusNotFound)
+		return
+	}
+	params := new(WorkerRegistration)
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil || params.URL == "" {
+		http.Error(w, "could not parse worker url", http.StatusBadRequest)
+		return
+	}
+	coordinator.Register(params.URL)
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleUnregisterWorker(w http.ResponseWriter, r *http.Request) {
+	if coordinator == nil {
+		http.Error(w, "not running as coordinator", http.StatusNotFound)
+		return
+	}
+	params := new(WorkerRegistration)
+	if err := json.NewDecoder(r.Body).Decode(params); err != nil || params.URL == "" {
+		http.Error(w, "could not parse worker url", http.StatusBadRequest)
+		return
+	}
+	coordinator.Unregister(params.URL)
+	w.WriteHeader(http.StatusOK)
+}
+
+func handleWorkers(w http.ResponseWriter, r *http.Request) {
+	if coordinator == nil {
+		http.Error(w, "not running as coordinator", http.StatusNotFound)
+		return
+	}
+	sendResponse(w, r, coordinator.Workers())
 }
 
 func handleHeartbeat(w http.ResponseWriter, _ *http.Request) {
+	if state.isDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -88,6 +591,24 @@ func router() *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc("/terminate", handleTerminate).Methods("POST")
 	r.HandleFunc("/evaluate", handleEvaluate).Methods("POST")
+	r.HandleFunc("/screen", handleScreen).Methods("POST")
+	r.HandleFunc("/strategies", handleStrategies).Methods("GET")
+	r.HandleFunc("/dependencies", handleDependencies).Methods("GET")
+	r.HandleFunc("/continue", handleContinue).Methods("POST")
+	r.HandleFunc("/strategies/{name}/evaluate", handleEvaluate).Methods("POST")
+	r.HandleFunc("/strategies/{name}/continue", handleContinue).Methods("POST")
+	r.HandleFunc("/strategies/{name}/screen", handleScreen).Methods("POST")
+	r.HandleFunc("/symbols", handleSymbols).Methods("GET")
+	r.HandleFunc("/runs", handleRuns).Methods("GET")
+	r.HandleFunc("/runs/{id}", handleRun).Methods("GET")
+	r.HandleFunc("/runs/{id}", handleDeleteRun).Methods("DELETE")
+	r.HandleFunc("/runs/{id}/results", handleRunResults).Methods("GET")
+	r.HandleFunc("/runs/{id}/diff/{other}", handleRunDiff).Methods("GET")
+	r.HandleFunc("/metrics", handleMetrics).Methods("GET")
+	r.HandleFunc("/workers", handleWorkers).Methods("GET")
+	r.HandleFunc("/workers", handleRegisterWorker).Methods("POST")
+	r.HandleFunc("/workers", handleUnregisterWorker).Methods("DELETE")
 	r.HandleFunc("/heartbeat", handleHeartbeat).Methods("GET")
+	r.Use(compressionMiddleware)
 	return r
 }
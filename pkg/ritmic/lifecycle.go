@@ -0,0 +1,59 @@
+package ritmic
+
+import (
+	"sync"
+	"time"
+)
+
+// lifecycle tracks running requests, once draining starts new requests are rejected while
+// the running ones are allowed to finish
+type lifecycle struct {
+	lock     sync.Mutex
+	draining bool
+	active   sync.WaitGroup
+}
+
+func newLifecycle() *lifecycle {
+	return &lifecycle{}
+}
+
+// begin registers a request, it returns false when the server is draining
+func (l *lifecycle) begin() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.draining {
+		return false
+	}
+	l.active.Add(1)
+	return true
+}
+
+func (l *lifecycle) end() {
+	l.active.Done()
+}
+
+func (l *lifecycle) isDraining() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.draining
+}
+
+// drain rejects new requests and waits for running requests, it returns false when they did
+// not finish within the timeout
+func (l *lifecycle) drain(timeout time.Duration) bool {
+	l.lock.Lock()
+	l.draining = true
+	l.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		l.active.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,112 @@
+package ritmic
+
+import (
+	"fmt"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLifecycleDrain(t *testing.T) {
+
+	l := newLifecycle()
+	if !l.begin() {
+		fmt.Println("expected request to be accepted")
+		t.Fail()
+	}
+
+	// running requests keep the drain waiting
+	if l.drain(10 * time.Millisecond) {
+		fmt.Println("expected drain to time out while a request is running")
+		t.Fail()
+	}
+	if l.begin() {
+		fmt.Println("expected request to be rejected while draining")
+		t.Fail()
+	}
+
+	l.end()
+	if !l.drain(time.Second) {
+		fmt.Println("expected drain to finish once requests ended")
+		t.Fail()
+	}
+}
+
+func TestConcurrentEvaluateTerminate(t *testing.T) {
+
+	useMarket()
+	drainTimeout = 30 * time.Second
+	started := make(chan struct{})
+	var once sync.Once
+	Register(DefaultStrategy, func(chart env.MarketSupplier, res *algo.ResultHandler, mem *env.Memory, params env.Parameters) {
+		once.Do(func() { close(started) })
+		crossStrategy(chart, res, mem, params)
+	}, []string{"threshold"})
+	defer unregister(DefaultStrategy)
+	server := httptest.NewServer(router())
+	defer server.Close()
+
+	// evaluations racing the terminate are either finished or rejected
+	body := `{"symbols":["TEST:X:A","TEST:X:B"],"scenarios":[[95],[100],[105]],"resolution":86400}`
+	var wg sync.WaitGroup
+	var served int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(server.URL+"/evaluate", "application/json", strings.NewReader(body))
+			if err != nil {
+				panic(err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				atomic.AddInt32(&served, 1)
+			} else if resp.StatusCode != http.StatusServiceUnavailable {
+				fmt.Printf("unexpected status %d\n", resp.StatusCode)
+				t.Fail()
+			}
+		}()
+	}
+
+	// terminate while evaluations are running
+	<-started
+
+	resp, err := http.Post(server.URL+"/terminate", "application/json", nil)
+	if err != nil {
+		panic(err)
+	}
+	_ = resp.Body.Close()
+	wg.Wait()
+	if served == 0 {
+		fmt.Println("expected evaluations started before terminating to finish")
+		t.Fail()
+	}
+
+	// after terminating no new work is accepted
+	resp, err = http.Post(server.URL+"/evaluate", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		panic(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		fmt.Printf("expected evaluate to be rejected but got %d\n", resp.StatusCode)
+		t.Fail()
+	}
+	resp, err = http.Get(server.URL + "/heartbeat")
+	if err != nil {
+		panic(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		fmt.Printf("expected heartbeat to report draining but got %d\n", resp.StatusCode)
+		t.Fail()
+	}
+}
@@ -0,0 +1,114 @@
+package ritmic
+
+import (
+	"sync"
+)
+
+// WorkerPool shares a global budget of workers between concurrent requests, free workers go
+// to the waiting request with the highest priority and among equals to the request holding
+// the fewest workers, so small requests are not starved by large ones
+type WorkerPool struct {
+	lock    sync.Mutex
+	size    int
+	running int
+	seq     uint64
+	waiting []*poolWaiter
+}
+
+type poolWaiter struct {
+	client *PoolClient
+	seq    uint64
+	ready  chan struct{}
+}
+
+// PoolClient acquires workers from the pool on behalf of a single request
+type PoolClient struct {
+	pool     *WorkerPool
+	priority int
+	running  int
+}
+
+// PoolMetrics describes the utilisation of the worker pool
+type PoolMetrics struct {
+	Size    int `json:"size"`
+	Running int `json:"running"`
+	Waiting int `json:"waiting"`
+}
+
+func NewWorkerPool(size int) *WorkerPool {
+	if size < 1 {
+		size = 1
+	}
+	return &WorkerPool{
+		size:    size,
+		waiting: make([]*poolWaiter, 0),
+	}
+}
+
+func (p *WorkerPool) Size() int {
+	return p.size
+}
+
+// Client creates a client for a request, higher priorities are served first
+func (p *WorkerPool) Client(priority int) *PoolClient {
+	return &PoolClient{pool: p, priority: priority}
+}
+
+func (p *WorkerPool) Metrics() *PoolMetrics {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return &PoolMetrics{
+		Size:    p.size,
+		Running: p.running,
+		Waiting: len(p.waiting),
+	}
+}
+
+// Acquire blocks until a worker is assigned to the client
+func (c *PoolClient) Acquire() {
+	p := c.pool
+	p.lock.Lock()
+	w := &poolWaiter{client: c, seq: p.seq, ready: make(chan struct{})}
+	p.seq++
+	p.waiting = append(p.waiting, w)
+	p.dispatch()
+	p.lock.Unlock()
+	<-w.ready
+}
+
+// Release returns a worker to the pool
+func (c *PoolClient) Release() {
+	p := c.pool
+	p.lock.Lock()
+	p.running--
+	c.running--
+	p.dispatch()
+	p.lock.Unlock()
+}
+
+// dispatch hands free workers to waiting clients, lock must be held
+func (p *WorkerPool) dispatch() {
+	for p.running < p.size && len(p.waiting) > 0 {
+		best := 0
+		for i, w := range p.waiting {
+			if w.before(p.waiting[best]) {
+				best = i
+			}
+		}
+		w := p.waiting[best]
+		p.waiting = append(p.waiting[:best], p.waiting[best+1:]...)
+		p.running++
+		w.client.running++
+		close(w.ready)
+	}
+}
+
+func (w *poolWaiter) before(other *poolWaiter) bool {
+	if w.client.priority != other.client.priority {
+		return w.client.priority > other.client.priority
+	}
+	if w.client.running != other.client.running {
+		return w.client.running < other.client.running
+	}
+	return w.seq < other.seq
+}
@@ -0,0 +1,98 @@
+package ritmic
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForWaiters blocks until the given amount of clients is queued
+func waitForWaiters(pool *WorkerPool, n int) {
+	for pool.Metrics().Waiting < n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWorkerPoolOrder(t *testing.T) {
+
+	pool := NewWorkerPool(1)
+	holder := pool.Client(0)
+	holder.Acquire()
+
+	// queue a large request holding no workers behind a small one and a priority one
+	large := pool.Client(0)
+	small := pool.Client(0)
+	urgent := pool.Client(1)
+
+	order := make([]string, 0)
+	lock := sync.Mutex{}
+	var wg sync.WaitGroup
+	run := func(name string, c *PoolClient) {
+		defer wg.Done()
+		c.Acquire()
+		lock.Lock()
+		order = append(order, name)
+		lock.Unlock()
+		c.Release()
+	}
+
+	wg.Add(1)
+	go run("large", large)
+	waitForWaiters(pool, 1)
+	wg.Add(1)
+	go run("small", small)
+	waitForWaiters(pool, 2)
+	wg.Add(1)
+	go run("urgent", urgent)
+	waitForWaiters(pool, 3)
+
+	holder.Release()
+	wg.Wait()
+
+	expected := []string{"urgent", "large", "small"}
+	for i := range expected {
+		if order[i] != expected[i] {
+			fmt.Printf("expected order %v but got %v\n", expected, order)
+			t.Fail()
+			break
+		}
+	}
+}
+
+func TestWorkerPoolFairness(t *testing.T) {
+
+	pool := NewWorkerPool(2)
+	large := pool.Client(0)
+	small := pool.Client(0)
+
+	// the large request occupies every worker
+	large.Acquire()
+	large.Acquire()
+
+	// both queue for the next worker, the request holding fewer workers goes first
+	done := make(chan string, 2)
+	go func() {
+		large.Acquire()
+		done <- "large"
+	}()
+	waitForWaiters(pool, 1)
+	go func() {
+		small.Acquire()
+		done <- "small"
+	}()
+	waitForWaiters(pool, 2)
+
+	large.Release()
+	if first := <-done; first != "small" {
+		fmt.Printf("expected small request to be served first but got %s\n", first)
+		t.Fail()
+	}
+	large.Release()
+	<-done
+
+	if m := pool.Metrics(); m.Running != 2 || m.Waiting != 0 {
+		fmt.Printf("unexpected pool state %+v\n", *m)
+		t.Fail()
+	}
+}
@@ -0,0 +1,243 @@
+package ritmic
+
+import (
+	"fmt"
+	"github.com/godoji/algocore/internal/simulation"
+	"log"
+	"runtime/debug"
+	"sort"
+	"sync"
+)
+
+// DefaultStrategy is the name of the strategy registered by Serve
+const DefaultStrategy = "default"
+
+// UnknownVersion is the version of strategies built without vcs information unless it is set
+// with SetVersion, builds with an unknown version cannot be told apart
+const UnknownVersion = "unknown"
+
+type strategy struct {
+	Name      string
+	Evaluator simulation.StepFunction
+	ParamKeys []string
+	// Version identifies the code of the strategy, it defaults to the build of the binary
+	Version string
+	// Declared dependencies are known up front, discovered ones were requested during evaluations
+	Declared   []simulation.Dependency
+	discovered map[string]simulation.Dependency
+}
+
+// StrategyInfo describes a hosted strategy and the parameters of its scenarios
+type StrategyInfo struct {
+	Name       string   `json:"name"`
+	Version    string   `json:"version"`
+	Parameters []string `json:"parameters"`
+}
+
+var (
+	strategies     = make(map[string]*strategy)
+	strategiesLock = sync.RWMutex{}
+)
+
+// Dependency is an algorithm or indicator used by a strategy
+type Dependency = simulation.Dependency
+
+// AlgorithmDependency declares an algorithm used by a strategy
+func AlgorithmDependency(name string, params ...float64) Dependency {
+	return simulation.Algorithm(name, params...)
+}
+
+// IndicatorDependency declares an indicator used by a strategy
+func IndicatorDependency(name string, interval int64, params ...int) Dependency {
+	return simulation.Indicator(name, interval, params...)
+}
+
+// StrategyDependencies describes what a strategy depends on, Order lists the algorithms in the
+// order they are fetched before an evaluation starts
+type StrategyDependencies struct {
+	Name       string                  `json:"name"`
+	Declared   []simulation.Dependency `json:"declared"`
+	Discovered []simulation.Dependency `json:"discovered"`
+	Order      []simulation.Dependency `json:"order"`
+	Error      string                  `json:"error,omitempty"`
+}
+
+// Register adds a named strategy to the server, names must be unique, the algorithms and
+// indicators it uses can be declared to fetch them before an evaluation starts
+func Register(name string, evaluate simulation.StepFunction, params []string, deps ...Dependency) {
+	strategiesLock.Lock()
+	defer strategiesLock.Unlock()
+	if name == "" {
+		log.Fatalln("strategy name cannot be empty")
+	}
+	if _, ok := strategies[name]; ok {
+		log.Fatalf("strategy \"%s\" is already registered\n", name)
+	}
+	strategies[name] = &strategy{
+		Name:       name,
+		Evaluator:  evaluate,
+		ParamKeys:  params,
+		Version:    buildVersion(),
+		Declared:   deps,
+		discovered: make(map[string]simulation.Dependency),
+	}
+}
+
+// SetVersion overrides the version identifier stored with the runs of a strategy
+func SetVersion(name string, version string) {
+	strategiesLock.Lock()
+	defer strategiesLock.Unlock()
+	st, ok := strategies[name]
+	if !ok {
+		log.Fatalf("strategy \"%s\" is not registered\n", name)
+	}
+	if version == "" {
+		version = UnknownVersion
+	}
+	st.Version = version
+}
+
+// buildVersion identifies the binary by its vcs revision, falling back to the module version
+// of binaries installed from a release
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return UnknownVersion
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	if revision == "" {
+		return UnknownVersion
+	}
+	if modified {
+		return revision + "-dirty"
+	}
+	return revision
+}
+
+// lookupStrategy finds a strategy by name, without a name the only registered strategy is used
+func lookupStrategy(name string) (*strategy, bool) {
+	strategiesLock.RLock()
+	defer strategiesLock.RUnlock()
+	if name != "" {
+		st, ok := strategies[name]
+		return st, ok
+	}
+	if st, ok := strategies[DefaultStrategy]; ok {
+		return st, true
+	}
+	if len(strategies) == 1 {
+		for _, st := range strategies {
+			return st, true
+		}
+	}
+	return nil, false
+}
+
+// dependency resolves registered strategies used as algorithms by other strategies
+func dependency(name string) (*simulation.LocalStrategy, bool) {
+	strategiesLock.RLock()
+	defer strategiesLock.RUnlock()
+	st, ok := strategies[name]
+	if !ok {
+		return nil, false
+	}
+	return &simulation.LocalStrategy{
+		Step:         st.Evaluator,
+		Keys:         st.ParamKeys,
+		Dependencies: st.dependencies(),
+	}, true
+}
+
+// dependencies merges declared and discovered dependencies, lock must be held
+func (st *strategy) dependencies() []simulation.Dependency {
+	deps := append(make([]simulation.Dependency, 0), st.Declared...)
+	for _, dep := range st.discovered {
+		deps = append(deps, dep)
+	}
+	return deps
+}
+
+// discover remembers the dependencies requested during an evaluation
+func discover(found map[string][]simulation.Dependency) {
+	strategiesLock.Lock()
+	defer strategiesLock.Unlock()
+	for name, deps := range found {
+		st, ok := strategies[name]
+		if !ok {
+			continue
+		}
+		for _, dep := range deps {
+			st.discovered[dep.Key()] = dep
+		}
+	}
+}
+
+// checkDependencies reports the first cyclic dependency between registered strategies
+func checkDependencies() error {
+	for _, info := range listDependencies() {
+		if info.Error != "" {
+			return fmt.Errorf("strategy \"%s\": %s", info.Name, info.Error)
+		}
+	}
+	return nil
+}
+
+func listDependencies() []*StrategyDependencies {
+	strategiesLock.RLock()
+	result := make([]*StrategyDependencies, 0, len(strategies))
+	for _, st := range strategies {
+		discovered := make([]simulation.Dependency, 0, len(st.discovered))
+		for _, dep := range st.discovered {
+			discovered = append(discovered, dep)
+		}
+		sort.Slice(discovered, func(i, j int) bool {
+			return discovered[i].Key() < discovered[j].Key()
+		})
+		result = append(result, &StrategyDependencies{
+			Name:       st.Name,
+			Declared:   append(make([]simulation.Dependency, 0), st.Declared...),
+			Discovered: discovered,
+		})
+	}
+	strategiesLock.RUnlock()
+
+	for _, info := range result {
+		order, err := simulation.DependencyOrder(info.Name, dependency)
+		if err != nil {
+			info.Error = err.Error()
+		}
+		info.Order = order
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+func listStrategies() []*StrategyInfo {
+	strategiesLock.RLock()
+	defer strategiesLock.RUnlock()
+	result := make([]*StrategyInfo, 0, len(strategies))
+	for _, st := range strategies {
+		result = append(result, &StrategyInfo{
+			Name:       st.Name,
+			Version:    st.Version,
+			Parameters: st.ParamKeys,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
@@ -0,0 +1,110 @@
+package ritmic
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func noopStrategy(_ env.MarketSupplier, _ *algo.ResultHandler, _ *env.Memory, _ env.Parameters) {}
+
+func unregister(names ...string) {
+	strategiesLock.Lock()
+	for _, name := range names {
+		delete(strategies, name)
+	}
+	strategiesLock.Unlock()
+}
+
+func TestStrategyRoutes(t *testing.T) {
+
+	state = newLifecycle()
+	Register("alpha", noopStrategy, []string{"period"})
+	Register("beta", noopStrategy, []string{"fast", "slow"})
+	defer unregister("alpha", "beta")
+
+	server := httptest.NewServer(router())
+	defer server.Close()
+
+	// listing describes the parameters of every strategy
+	resp, err := http.Get(server.URL + "/strategies")
+	if err != nil {
+		panic(err)
+	}
+	infos := make([]*StrategyInfo, 0)
+	if err = json.NewDecoder(resp.Body).Decode(&infos); err != nil {
+		panic(err)
+	}
+	_ = resp.Body.Close()
+	if len(infos) != 2 || infos[0].Name != "alpha" || len(infos[1].Parameters) != 2 {
+		fmt.Printf("unexpected strategy listing %v\n", infos)
+		t.Fail()
+	}
+
+	// named routes reach the strategy, the short route is ambiguous with several strategies
+	expected := map[string]int{
+		"/strategies/alpha/evaluate": http.StatusBadRequest,
+		"/strategies/gamma/evaluate": http.StatusNotFound,
+		"/evaluate":                  http.StatusNotFound,
+	}
+	for path, code := range expected {
+		resp, err = http.Post(server.URL+path, "application/json", strings.NewReader("{}"))
+		if err != nil {
+			panic(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != code {
+			fmt.Printf("expected %s to respond with %d but got %d\n", path, code, resp.StatusCode)
+			t.Fail()
+		}
+	}
+}
+
+func TestStrategyDependencies(t *testing.T) {
+
+	Register("trend", noopStrategy, nil, AlgorithmDependency("highs-and-lows", 7))
+	Register("entry", noopStrategy, nil, AlgorithmDependency("trend"), IndicatorDependency("sma", 60, 20))
+	defer unregister("trend", "entry")
+
+	if err := checkDependencies(); err != nil {
+		fmt.Printf("unexpected dependency error: %s\n", err.Error())
+		t.Fail()
+	}
+	for _, info := range listDependencies() {
+		if info.Name == "entry" && (len(info.Order) != 2 || info.Order[0].Name != "highs-and-lows") {
+			fmt.Printf("unexpected dependency order %v\n", info.Order)
+			t.Fail()
+		}
+	}
+
+	// a discovered dependency closing a loop is reported
+	discover(map[string][]Dependency{"trend": {AlgorithmDependency("entry")}})
+	if err := checkDependencies(); err == nil {
+		fmt.Println("expected cyclic dependency to be reported")
+		t.Fail()
+	}
+}
+
+func TestUnknownVersion(t *testing.T) {
+
+	// test binaries carry no vcs information
+	Register("unstamped", noopStrategy, []string{})
+	defer unregister("unstamped")
+	st, _ := lookupStrategy("unstamped")
+	if st.Version != UnknownVersion {
+		fmt.Printf("expected an unknown version without vcs information but got %s\n", st.Version)
+		t.Fail()
+	}
+
+	SetVersion("unstamped", "v1")
+	SetVersion("unstamped", "")
+	if st.Version != UnknownVersion {
+		fmt.Printf("expected an empty version to be unknown but got %s\n", st.Version)
+		t.Fail()
+	}
+}
@@ -0,0 +1,311 @@
+package ritmic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/compression"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var ErrRunNotFound = errors.New("run not found")
+
+var runIDPattern = regexp.MustCompile(`^[0-9a-z-]+$`)
+
+const (
+	runInfoFile    = "run.json"
+	runResultsFile = "results.algo.zst"
+)
+
+// RunInfo describes a stored evaluation, Until is the last candle time of any symbol
+type RunInfo struct {
+	ID        string          `json:"id"`
+	Strategy  string          `json:"strategy"`
+	Version   string          `json:"version"`
+	Config    *EvaluateConfig `json:"config"`
+	Status    *algo.Status    `json:"status"`
+	CreatedAt int64           `json:"createdAt"`
+	Until     int64           `json:"until"`
+	Events    int             `json:"events"`
+}
+
+// RunStore keeps evaluations on disk, every run is a directory with its description and the
+// results in the binary format
+type RunStore struct {
+	dir  string
+	lock sync.Mutex
+	last int64
+}
+
+func NewRunStore(dir string) (*RunStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &RunStore{dir: dir}, nil
+}
+
+// nextID derives a sortable id from the current time
+func (s *RunStore) nextID() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now().UTC().UnixNano()
+	if now <= s.last {
+		now = s.last + 1
+	}
+	s.last = now
+	return strconv.FormatInt(now, 36)
+}
+
+func (s *RunStore) path(id string, name string) (string, error) {
+	if !runIDPattern.MatchString(id) {
+		return "", ErrRunNotFound
+	}
+	return filepath.Join(s.dir, id, name), nil
+}
+
+// Save stores the results of a run and fills in its id and summary
+func (s *RunStore) Save(run *RunInfo, results *algo.ResultSet) error {
+
+	run.ID = s.nextID()
+	run.CreatedAt = time.Now().UTC().Unix()
+	run.Until, run.Events = 0, 0
+	for _, set := range results.Symbols {
+		if set.LastTime > run.Until {
+			run.Until = set.LastTime
+		}
+		for _, scenario := range set.Scenarios {
+			if scenario != nil {
+				run.Events += len(scenario.Events)
+			}
+		}
+	}
+
+	// write to a temporary directory first so listings never see partial runs
+	tmp := filepath.Join(s.dir, run.ID+".tmp")
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+	err := writeRunFile(filepath.Join(tmp, runResultsFile), func(w io.Writer) error {
+		zw, err := compression.NewWriter(compression.Zstd, w)
+		if err != nil {
+			return err
+		}
+		if err = algo.EncodeResultSet(zw, results); err != nil {
+			return err
+		}
+		return zw.Close()
+	})
+	if err == nil {
+		err = writeRunFile(filepath.Join(tmp, runInfoFile), func(w io.Writer) error {
+			return json.NewEncoder(w).Encode(run)
+		})
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(s.dir, run.ID))
+	}
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+	}
+	return err
+}
+
+func writeRunFile(path string, write func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// List returns the stored runs, optionally of a single strategy, newest first
+func (s *RunStore) List(strategy string) ([]*RunInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*RunInfo, 0)
+	for _, entry := range entries {
+		if !entry.IsDir() || filepath.Ext(entry.Name()) == ".tmp" {
+			continue
+		}
+		run, err := s.Get(entry.Name())
+		if errors.Is(err, ErrRunNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strategy == "" || run.Strategy == strategy {
+			result = append(result, run)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if len(result[i].ID) != len(result[j].ID) {
+			return len(result[i].ID) > len(result[j].ID)
+		}
+		return result[i].ID > result[j].ID
+	})
+	return result, nil
+}
+
+func (s *RunStore) Get(id string) (*RunInfo, error) {
+	path, err := s.path(id, runInfoFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrRunNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	run := new(RunInfo)
+	if err = json.Unmarshal(data, run); err != nil {
+		return nil, fmt.Errorf("run %s is corrupt: %w", id, err)
+	}
+	return run, nil
+}
+
+func (s *RunStore) Results(id string) (*algo.ResultSet, error) {
+	path, err := s.path(id, runResultsFile)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrRunNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	r, err := compression.NewReader(compression.Zstd, f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	defer r.Close()
+	return algo.DecodeResultSet(r)
+}
+
+func (s *RunStore) Delete(id string) error {
+	path, err := s.path(id, "")
+	if err != nil {
+		return err
+	}
+	if _, err = os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return ErrRunNotFound
+	}
+	return os.RemoveAll(path)
+}
+
+// ScenarioDiff lists the events of a scenario only found in one of two runs
+type ScenarioDiff struct {
+	Symbol   string        `json:"symbol"`
+	Scenario int           `json:"scenario"`
+	Added    []*algo.Event `json:"added"`
+	Removed  []*algo.Event `json:"removed"`
+}
+
+type RunDiff struct {
+	From        string          `json:"from"`
+	To          string          `json:"to"`
+	FromVersion string          `json:"fromVersion"`
+	ToVersion   string          `json:"toVersion"`
+	Scenarios   []*ScenarioDiff `json:"scenarios"`
+}
+
+// DiffResults compares the events of every symbol and scenario, events are equal when their
+// times, price and label match. Scenarios without differences are left out
+func DiffResults(from *algo.ResultSet, to *algo.ResultSet) []*ScenarioDiff {
+
+	symbols := make(map[string]bool)
+	for symbol := range from.Symbols {
+		symbols[symbol] = true
+	}
+	for symbol := range to.Symbols {
+		symbols[symbol] = true
+	}
+	sorted := make([]string, 0, len(symbols))
+	for symbol := range symbols {
+		sorted = append(sorted, symbol)
+	}
+	sort.Strings(sorted)
+
+	result := make([]*ScenarioDiff, 0)
+	for _, symbol := range sorted {
+		a, b := scenariosOf(from, symbol), scenariosOf(to, symbol)
+		n := len(a)
+		if len(b) > n {
+			n = len(b)
+		}
+		for i := 0; i < n; i++ {
+			diff := &ScenarioDiff{
+				Symbol:   symbol,
+				Scenario: i,
+				Added:    make([]*algo.Event, 0),
+				Removed:  make([]*algo.Event, 0),
+			}
+			before, after := eventsAt(a, i), eventsAt(b, i)
+			counts := make(map[string]int)
+			for _, e := range before {
+				counts[eventKey(e)]++
+			}
+			for _, e := range after {
+				key := eventKey(e)
+				if counts[key] > 0 {
+					counts[key]--
+					continue
+				}
+				diff.Added = append(diff.Added, e)
+			}
+			for _, e := range before {
+				key := eventKey(e)
+				if counts[key] > 0 {
+					counts[key]--
+					diff.Removed = append(diff.Removed, e)
+				}
+			}
+			if len(diff.Added) > 0 || len(diff.Removed) > 0 {
+				result = append(result, diff)
+			}
+		}
+	}
+	return result
+}
+
+func scenariosOf(set *algo.ResultSet, symbol string) []*algo.ScenarioSet {
+	if s, ok := set.Symbols[symbol]; ok {
+		return s.Scenarios
+	}
+	return nil
+}
+
+func eventsAt(scenarios []*algo.ScenarioSet, i int) []*algo.Event {
+	events := make([]*algo.Event, 0)
+	if i >= len(scenarios) || scenarios[i] == nil {
+		return events
+	}
+	for _, e := range scenarios[i].Events {
+		if e != nil {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func eventKey(e *algo.Event) string {
+	return fmt.Sprintf("%d:%d:%s:%s", e.CreatedOn, e.Time, strconv.FormatFloat(e.Price, 'g', -1, 64), e.Label)
+}
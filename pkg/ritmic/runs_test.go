@@ -0,0 +1,124 @@
+package ritmic
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/godoji/algocore/pkg/algo"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func runResults(labels ...string) *algo.ResultSet {
+	events := make([]*algo.Event, len(labels))
+	for i, label := range labels {
+		events[i] = &algo.Event{Time: int64(i * 60), Label: label}
+	}
+	return &algo.ResultSet{Symbols: map[string]*algo.SymbolResultSet{
+		"A:B:C": {LastTime: 600, Scenarios: []*algo.ScenarioSet{{Parameters: []float64{1}, Events: events}}},
+	}}
+}
+
+func TestRunStore(t *testing.T) {
+
+	store, err := NewRunStore(t.TempDir())
+	if err != nil {
+		panic(err)
+	}
+
+	first := &RunInfo{Strategy: "a", Version: "1", Config: &EvaluateConfig{Resolution: 60}, Status: &algo.Status{Finished: true}}
+	if err = store.Save(first, runResults("high", "low")); err != nil {
+		panic(err)
+	}
+	second := &RunInfo{Strategy: "b", Version: "2", Config: &EvaluateConfig{Resolution: 60}, Status: &algo.Status{Finished: true}}
+	if err = store.Save(second, runResults("high")); err != nil {
+		panic(err)
+	}
+	if first.Events != 2 || first.Until != 600 {
+		fmt.Printf("unexpected summary of run: %d events until %d\n", first.Events, first.Until)
+		t.Fail()
+	}
+
+	list, err := store.List("")
+	if err != nil {
+		panic(err)
+	}
+	if len(list) != 2 || list[0].ID != second.ID {
+		fmt.Println("expected newest run first")
+		t.Fail()
+	}
+	if list, _ = store.List("a"); len(list) != 1 || list[0].Version != "1" {
+		fmt.Println("expected runs to be filtered by strategy")
+		t.Fail()
+	}
+
+	results, err := store.Results(first.ID)
+	if err != nil {
+		panic(err)
+	}
+	if !reflect.DeepEqual(results, runResults("high", "low")) {
+		fmt.Println("stored results differ")
+		t.Fail()
+	}
+
+	if _, err = store.Get("../" + first.ID); err != ErrRunNotFound {
+		fmt.Printf("expected paths outside the store to be rejected but got %v\n", err)
+		t.Fail()
+	}
+	if err = store.Delete(first.ID); err != nil {
+		panic(err)
+	}
+	if _, err = store.Get(first.ID); err != ErrRunNotFound {
+		fmt.Printf("expected deleted run to be gone but got %v\n", err)
+		t.Fail()
+	}
+}
+
+func TestRunDiff(t *testing.T) {
+
+	store, err := NewRunStore(t.TempDir())
+	if err != nil {
+		panic(err)
+	}
+	runs = store
+	defer func() { runs = nil }()
+
+	before, after := &RunInfo{Strategy: "a", Version: UnknownVersion}, &RunInfo{Strategy: "a", Version: "2"}
+	_ = store.Save(before, runResults("high", "low"))
+	_ = store.Save(after, runResults("high", "high"))
+
+	r := httptest.NewRequest(http.MethodGet, "/runs/"+before.ID+"/diff/"+after.ID, nil)
+	w := httptest.NewRecorder()
+	router().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		fmt.Printf("diff failed with code %d\n", w.Code)
+		t.FailNow()
+	}
+	diff := new(RunDiff)
+	if err = json.NewDecoder(w.Body).Decode(diff); err != nil {
+		panic(err)
+	}
+	if diff.FromVersion != UnknownVersion || diff.ToVersion != "2" {
+		fmt.Printf("expected the versions of both runs but got %s and %s\n", diff.FromVersion, diff.ToVersion)
+		t.Fail()
+	}
+	if len(diff.Scenarios) != 1 {
+		fmt.Printf("expected a single changed scenario but got %d\n", len(diff.Scenarios))
+		t.FailNow()
+	}
+	changed := diff.Scenarios[0]
+	if len(changed.Added) != 1 || changed.Added[0].Time != 60 || changed.Added[0].Label != "high" ||
+		len(changed.Removed) != 1 || changed.Removed[0].Label != "low" {
+		fmt.Println("unexpected events in diff")
+		t.Fail()
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/runs/missing/diff/"+after.ID, nil)
+	w = httptest.NewRecorder()
+	router().ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		fmt.Printf("expected missing run to be reported but got %d\n", w.Code)
+		t.Fail()
+	}
+}
@@ -1,30 +1,69 @@
 package ritmic
 
 import (
+	"context"
 	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/godoji/algocore/internal/simulation"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/kiosk"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
-type strategy struct {
-	Evaluator simulation.StepFunction
-	ParamKeys []string
+var srv *http.Server
+var pool *WorkerPool
+var coordinator *Coordinator
+var runs *RunStore
+var resultCache *simulation.ScenarioCache
+var state = newLifecycle()
+var drainTimeout = 30 * time.Second
+var shutdownOnce sync.Once
+
+// Serve hosts a single strategy, it is a shortcut for registering it as the default strategy
+func Serve(evaluate simulation.StepFunction, params []string) {
+	Register(DefaultStrategy, evaluate, params)
+	ServeAll()
 }
 
-var srv *http.Server
-var s *strategy
+// ServeAll hosts every registered strategy
+func ServeAll() {
 
-func Serve(evaluate simulation.StepFunction, params []string) {
+	if err := checkDependencies(); err != nil {
+		log.Fatalln(err)
+	}
 
-	s = &strategy{
-		Evaluator: evaluate,
-		ParamKeys: params,
+	cfg, err := parseConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err = kiosk.Setup(cfg.Kiosk); err != nil {
+		log.Fatalln(err)
+	}
+	pool = NewWorkerPool(cfg.Workers)
+	drainTimeout = cfg.DrainTimeout
+	if cfg.ResultCache > 0 {
+		resultCache = simulation.NewScenarioCache(cfg.ResultCache)
+	}
+	if cfg.CheckpointKey != "" {
+		checkpointKey = []byte(cfg.CheckpointKey)
+	}
+	if cfg.RunsDir != "" {
+		if runs, err = NewRunStore(cfg.RunsDir); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if cfg.Coordinator {
+		coordinator = NewCoordinator(cfg.Peers)
+		go coordinator.Watch(5*time.Second, make(chan struct{}))
 	}
 
 	port := os.Getenv("PORT")
@@ -35,30 +74,134 @@ func Serve(evaluate simulation.StepFunction, params []string) {
 		Addr:    ":" + port,
 		Handler: router(),
 	}
+
+	// drain running requests before shutting down on termination signals
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		log.Printf("received %s, draining requests\n", sig)
+		if !state.drain(drainTimeout) {
+			log.Printf("running requests did not finish within %s\n", drainTimeout)
+		}
+		shutdown()
+	}()
+
 	fmt.Printf("Listening on port %s\n", port)
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalln(err)
 	}
 }
 
+// shutdown stops the server once, connections still open after the drain are closed
+func shutdown() {
+	shutdownOnce.Do(func() {
+		if srv == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("graceful shutdown failed: %s\n", err.Error())
+			_ = srv.Close()
+		}
+	})
+}
+
 func sendAsJSON(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(data)
 }
 
+func sendErrors(w http.ResponseWriter, code int, errs interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": errs})
+}
+
 func sendAsBinary(w http.ResponseWriter, data interface{}) {
 	w.Header().Set("Content-Type", "application/octet-stream")
 	w.WriteHeader(http.StatusOK)
 	_ = gob.NewEncoder(w).Encode(data)
 }
 
+// sendAsBinaryV2 sends results in the versioned binary format, it returns false for data
+// which has no binary representation
+func sendAsBinaryV2(w http.ResponseWriter, data interface{}) bool {
+	var encode func() error
+	switch v := data.(type) {
+	case *algo.ResultSet:
+		encode = func() error { return algo.EncodeResultSet(w, v) }
+	case *algo.ScenarioSet:
+		encode = func() error { return algo.EncodeScenarioSet(w, v) }
+	default:
+		return false
+	}
+	w.Header().Set("Content-Type", algo.BinaryMediaType)
+	w.WriteHeader(http.StatusOK)
+	if err := encode(); err != nil {
+		log.Printf("could not send binary response: %s\n", err.Error())
+	}
+	return true
+}
+
+// sendAsTable sends one table of flattened results selected by the table query parameter,
+// events by default. It returns false for data which is not a result set
+func sendAsTable(w http.ResponseWriter, r *http.Request, data interface{}, mediaType string) bool {
+	set, ok := data.(*algo.ResultSet)
+	if !ok {
+		return false
+	}
+	name := r.URL.Query().Get("table")
+	if name == "" {
+		name = algo.EventsTable
+	}
+	table, err := algo.FlattenTable(set, name)
+	if err != nil {
+		sendErrors(w, http.StatusBadRequest, []string{err.Error()})
+		return true
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+table.Name+tableExtension(mediaType)+"\"")
+	w.WriteHeader(http.StatusOK)
+	if mediaType == algo.CSVMediaType {
+		err = table.WriteCSV(w)
+	} else {
+		err = table.WriteParquet(w)
+	}
+	if err != nil {
+		log.Printf("could not send %s table: %s\n", table.Name, err.Error())
+	}
+	return true
+}
+
+func tableExtension(mediaType string) string {
+	if mediaType == algo.CSVMediaType {
+		return ".csv"
+	}
+	return ".parquet"
+}
+
 func sendResponse(w http.ResponseWriter, r *http.Request, data interface{}) {
 
 	// try to satisfy accept header
 	accepts := r.Header.Get("Accept")
 
+	// send in the binary format when requested and available for the data
+	if strings.Index(accepts, algo.BinaryMediaType) != -1 && sendAsBinaryV2(w, data) {
+		return
+	}
+
+	// send a flattened table when requested and available for the data
+	if strings.Index(accepts, algo.CSVMediaType) != -1 && sendAsTable(w, r, data, algo.CSVMediaType) {
+		return
+	}
+	if strings.Index(accepts, algo.ParquetMediaType) != -1 && sendAsTable(w, r, data, algo.ParquetMediaType) {
+		return
+	}
+
 	// send as json when nothing is specified
 	if accepts == "" {
 		sendAsJSON(w, data)
@@ -0,0 +1,223 @@
+package ritmic
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"github.com/godoji/algocore/internal/kiosktest"
+	"github.com/godoji/algocore/internal/simulation"
+	"github.com/godoji/algocore/pkg/algo"
+	"github.com/godoji/algocore/pkg/env"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSendResponseNegotiation(t *testing.T) {
+
+	results := &algo.ResultSet{Symbols: map[string]*algo.SymbolResultSet{
+		"A:B:C": {LastTime: 60, Scenarios: []*algo.ScenarioSet{{Parameters: []float64{1}, Events: []*algo.Event{}}}},
+	}}
+
+	respond := func(accept string, data interface{}) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		sendResponse(w, r, data)
+		return w
+	}
+
+	// the binary format is preferred when accepted
+	w := respond(algo.BinaryMediaType+", application/octet-stream;q=0.9", results)
+	if w.Header().Get("Content-Type") != algo.BinaryMediaType {
+		fmt.Printf("expected binary format but got %s\n", w.Header().Get("Content-Type"))
+		t.Fail()
+	} else if decoded, err := algo.DecodeResultSet(w.Body); err != nil || decoded.Symbols["A:B:C"].LastTime != 60 {
+		fmt.Printf("could not decode binary results: %v\n", err)
+		t.Fail()
+	}
+
+	// gob is kept for older clients
+	w = respond("application/octet-stream", results)
+	decoded := new(algo.ResultSet)
+	if err := gob.NewDecoder(w.Body).Decode(decoded); err != nil || decoded.Symbols["A:B:C"].LastTime != 60 {
+		fmt.Printf("could not decode gob results: %v\n", err)
+		t.Fail()
+	}
+
+	// data without a binary representation falls back to other accepted types
+	w = respond(algo.BinaryMediaType+", application/json", []string{"a"})
+	if w.Header().Get("Content-Type") != "application/json" {
+		fmt.Printf("expected json fallback but got %s\n", w.Header().Get("Content-Type"))
+		t.Fail()
+	}
+
+	// flattened tables are selected through the table query parameter
+	r := httptest.NewRequest(http.MethodGet, "/?table=parameters", nil)
+	r.Header.Set("Accept", algo.CSVMediaType)
+	w = httptest.NewRecorder()
+	sendResponse(w, r, results)
+	if w.Body.String() != "symbol,scenario,parameter,value\nA:B:C,0,0,1\n" {
+		fmt.Printf("unexpected csv table: %q\n", w.Body.String())
+		t.Fail()
+	}
+	r = httptest.NewRequest(http.MethodGet, "/?table=trades", nil)
+	r.Header.Set("Accept", algo.ParquetMediaType)
+	w = httptest.NewRecorder()
+	sendResponse(w, r, results)
+	if w.Code != http.StatusBadRequest {
+		fmt.Printf("expected bad request for unknown table but got %d\n", w.Code)
+		t.Fail()
+	}
+}
+
+func TestEvaluateAlgorithmError(t *testing.T) {
+
+	// the algorithm service is not configured for the synthetic market
+	useMarket()
+	Register("broken", func(chart env.MarketSupplier, res *algo.ResultHandler, mem *env.Memory, params env.Parameters) {
+		if chart.Algorithm("unknown").HasEvents() {
+			res.NewEvent("unknown")
+		}
+	}, []string{})
+	defer unregister("broken")
+
+	body := `{"symbols":["TEST:X:A"],"scenarios":[[]],"resolution":86400}`
+	r := httptest.NewRequest(http.MethodPost, "/strategies/broken/evaluate", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router().ServeHTTP(w, r)
+	if w.Code != http.StatusBadGateway {
+		fmt.Printf("expected failed algorithm to fail the request but got %d\n", w.Code)
+		t.Fail()
+	}
+}
+
+func TestDependencyError(t *testing.T) {
+
+	// declared dependencies are fetched before the simulation starts
+	useMarket()
+	Register("dependent", noopStrategy, []string{}, AlgorithmDependency("unknown"))
+	defer unregister("dependent")
+
+	body := `{"symbols":["TEST:X:A"],"scenarios":[[]],"resolution":86400}`
+	for _, path := range []string{"/strategies/dependent/evaluate", "/strategies/dependent/screen", "/strategies/dependent/continue"} {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router().ServeHTTP(w, r)
+		if w.Code != http.StatusBadGateway {
+			fmt.Printf("expected failed dependency to fail %s but got %d\n", path, w.Code)
+			t.Fail()
+		}
+	}
+}
+
+func TestSymbolsFilter(t *testing.T) {
+
+	useMarket()
+	for query, expected := range map[string][]string{
+		"":                             {"DEMO:X:F", "TEST:X:A", "TEST:X:B", "TEST:X:C", "TEST:X:D", "TEST:Y:E"},
+		"?broker=DEMO":                 {"DEMO:X:F"},
+		"?broker=TEST&exchange=Y":      {"TEST:Y:E"},
+		"?exchange=X":                  {"DEMO:X:F", "TEST:X:A", "TEST:X:B", "TEST:X:C", "TEST:X:D"},
+		"?onBoardAfter=" + onBoard(0):  {"TEST:Y:E"},
+		"?onBoardBefore=" + onBoard(0): {"DEMO:X:F"},
+		"?onBoardBefore=" + onBoard(1) + "&broker=TEST": {"TEST:X:A", "TEST:X:B", "TEST:X:C", "TEST:X:D"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/symbols"+query, nil)
+		w := httptest.NewRecorder()
+		router().ServeHTTP(w, r)
+		symbols := make([]*SymbolInfo, 0)
+		if err := json.NewDecoder(w.Body).Decode(&symbols); err != nil {
+			panic(err)
+		}
+		names := make([]string, len(symbols))
+		for i, info := range symbols {
+			names[i] = info.Symbol
+		}
+		if !reflect.DeepEqual(names, expected) {
+			fmt.Printf("expected %v for \"%s\" but got %v\n", expected, query, names)
+			t.Fail()
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/symbols?onBoardAfter=yesterday", nil)
+	w := httptest.NewRecorder()
+	router().ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		fmt.Printf("expected invalid filter to be rejected but got %d\n", w.Code)
+		t.Fail()
+	}
+}
+
+// onBoard formats the on-board date of the synthetic market moved by the given seconds
+func onBoard(offset int64) string {
+	return strconv.FormatInt(kiosktest.OnBoard+offset, 10)
+}
+
+func TestScreenEndpoint(t *testing.T) {
+
+	useMarket()
+	Register("cross", crossStrategy, []string{"threshold"})
+	defer unregister("cross")
+
+	// find a time at which the price crosses the threshold
+	evaluator, err := simulation.NewEvaluator(simulation.EvalOptions{
+		Step:       crossStrategy,
+		Resolution: 3600,
+		Symbols:    []string{"TEST:X:A"},
+		From:       kiosktest.OnBoard,
+		Until:      kiosktest.OnBoard + 3600*2000,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if err = evaluator.Run([][]float64{{100}}, []string{"threshold"}); err != nil {
+		panic(err)
+	}
+	events := evaluator.Results().Symbols["TEST:X:A"].Scenarios[0].Events
+	if len(events) < 2 {
+		fmt.Println("expected the price to cross the threshold")
+		t.FailNow()
+	}
+	at := events[1].CreatedOn
+
+	body := fmt.Sprintf(`{"symbols":["TEST:X:*"],"scenarios":[[100]],"resolution":3600,"time":%d,"warmup":%d}`, at, 3600*200)
+	r := httptest.NewRequest(http.MethodPost, "/strategies/cross/screen", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		fmt.Printf("screen failed with code %d: %s\n", w.Code, w.Body.String())
+		t.FailNow()
+	}
+	result := new(simulation.ScreenResult)
+	if err = json.NewDecoder(w.Body).Decode(result); err != nil {
+		panic(err)
+	}
+
+	// only events at the screened time are sent
+	found := false
+	for _, match := range result.Matches {
+		for _, e := range match.Events {
+			if match.Time != at || e.CreatedOn != at {
+				fmt.Printf("expected only events at %d but got %s at %d\n", at, match.Symbol, e.CreatedOn)
+				t.Fail()
+			}
+		}
+		found = found || match.Symbol == "TEST:X:A"
+	}
+	if !found {
+		fmt.Printf("expected TEST:X:A to match at %d\n", at)
+		t.Fail()
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/strategies/cross/screen", strings.NewReader(`{"symbols":[],"resolution":3600}`))
+	w = httptest.NewRecorder()
+	router().ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		fmt.Printf("expected screen without symbols to be rejected but got %d\n", w.Code)
+		t.Fail()
+	}
+}
@@ -8,17 +8,41 @@ import (
 )
 
 func RunTestShort(step simulation.StepFunction, scenarios [][]float64, paramKeys []string) *simulation.Evaluator {
-	bot := simulation.NewEvaluator(simulation.EvalOptions{
+	bot, err := simulation.NewEvaluator(simulation.EvalOptions{
 		Step:       step,
 		Resolution: candlestick.Interval1d,
 		Symbols:    []string{"UNICORN:US:COKE"},
 	})
+	if err != nil {
+		log.Fatalln(err)
+	}
 	bot.SetMaxThreads(1)
-	bot.Run(scenarios, paramKeys)
-	_, err := json.Marshal(bot.Results())
+	if err = bot.Run(scenarios, paramKeys); err != nil {
+		log.Fatalln(err)
+	}
+	_, err = json.Marshal(bot.Results())
 	if err != nil {
 		log.Println("could not save results")
 		log.Fatalln(err)
 	}
 	return bot
 }
+
+func RunLookaheadTest(step simulation.StepFunction, scenarios [][]float64, paramKeys []string, truncations int) *simulation.LookaheadReport {
+	report, err := simulation.DetectLookahead(simulation.EvalOptions{
+		Step:       step,
+		Resolution: candlestick.Interval1d,
+		Symbols:    []string{"UNICORN:US:COKE"},
+	}, scenarios, paramKeys, truncations)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, v := range report.Violations {
+		log.Printf("lookahead: %s read data at %d during step %d (%dx) at %s\n", v.Method, v.Accessed, v.Time, v.Count, v.CallSite)
+	}
+	for _, m := range report.Mismatches {
+		log.Printf("lookahead: %s scenario %d differs when truncated at %d, first at %d (%d vs %d events)\n",
+			m.Symbol, m.Scenario, m.Cutoff, m.Difference, m.Expected, m.Actual)
+	}
+	return report
+}